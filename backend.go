@@ -1,9 +1,10 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -15,9 +16,13 @@ const defaultFileName = "newfile"
 
 // backend holds the buffers open in the editor
 type backend struct {
-	bufs        []*buffer // the open buffers
-	msgLine     line      // to hold messages to display to user
-	commandMode bool      // wether we are in command mode
+	bufs        []*buffer     // the open buffers
+	lastID      int           // the number of the last buffer added
+	alternate   *buffer       // the buffer shown before the current one
+	msgLine     line          // to hold messages to display to user
+	commandMode bool          // wether we are in command mode
+	searchMode  bool          // wether a search is being typed
+	confirm     *substitution // the substitution asking for confirmation
 }
 
 // initBackend returns the backend after having initialized it
@@ -27,17 +32,6 @@ func initBackend() backend {
 	return be
 }
 
-type filetype int
-
-const (
-	anyFiletype filetype = iota
-	_go
-)
-
-var filetypes = map[string]filetype{
-	".go": _go,
-}
-
 // open takes a list of filenames and open a buffer for each returning the
 // first one as current buffer; if the list in empty it returns a new buffer.
 // Non-existing filenames will also open new buffers
@@ -45,16 +39,18 @@ func (be *backend) open(filenames []string) *buffer {
 	if len(filenames) == 0 {
 		return be.newBuffer("")
 	}
-	i, fn := 0, ""
-	for i, fn = range filenames {
+	var first *buffer
+	for _, fn := range filenames {
 		b := be.newBuffer("")
 		err := be.openFile(b, fn)
 		if err != nil {
-			b.text[0] = line(fmt.Sprint(err))
+			b.text = newRope([]line{line(fmt.Sprint(err) + "\n")})
+		}
+		if first == nil {
+			first = b
 		}
-		be.bufs = append(be.bufs, b)
 	}
-	return be.bufs[len(be.bufs)-i-1]
+	return first
 }
 
 // newBuffer adds a new empty buffer to the backend and returns a pointer to it
@@ -65,45 +61,76 @@ func (be *backend) newBuffer(name string) *buffer {
 		name = defaultFileName
 	}
 	b := &buffer{
-		text:       make([]line, 1, 20),
-		name:       name,
-		filename:   filename(name),
-		changeList: changeList{ops: make([]bufferChange, 1)},
+		text:     newRope(nil),
+		name:     name,
+		filename: filename(name),
 	}
+	b.setFiletype(anyFiletype)
+	b.setSaved()
 	newMark(b).initLastInsert()
-	b.text[0] = newLine()
+	be.lastID++
+	b.id = be.lastID
 	be.bufs = append(be.bufs, b)
 	return b
 }
 
-// reopen refresh the buffer contenct from the file, useful if an external command
-// changed the file
-func (b *buffer) reopen() {
-	err := be.openFile(b, b.filename)
-	if err != nil {
-		debug.Printf("buffer reopen error: %v", err)
+// bufferFor returns the buffer of the file at path fp, opening it if it is
+// not open yet
+func (be *backend) bufferFor(fp string) (*buffer, error) {
+	if b := be.findBuffer(fp); b != nil {
+		return b, nil
 	}
+	b := be.newBuffer(fp)
+	if err := be.openFile(b, fp); err != nil {
+		be.bufs = be.bufs[:len(be.bufs)-1]
+		return nil, err
+	}
+	return b, nil
 }
 
-// save saves the buffer
-func (b *buffer) save() error {
-	return b.saveAs(b.filename)
+// findBuffer returns the buffer of the file at path fp, nil if there is none
+func (be *backend) findBuffer(fp string) *buffer {
+	fp = filename(fp)
+	for _, b := range be.bufs {
+		if b.filename == fp {
+			return b
+		}
+	}
+	return nil
 }
 
-// saveAs saves the buffer in a file named after the passed parameter
-func (b *buffer) saveAs(filename string) error {
-	f, err := os.Create(filename)
+// reopen refreshes the buffer content from the file, useful if an external
+// command changed the file. The new text replaces the old one as a single
+// change, moving the cursor cs and the marks with their lines
+func (b *buffer) reopen(cs *mark) error {
+	f, err := os.Open(b.filename)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	b.replaceText(ropeFromBytes(content).all(), cs)
+	b.fileContent, b.fileSync = content, fileTime(f)
+	b.setSaved()
+	return nil
+}
 
-	br := &bufReader{b, 0, 0}
-	_, err = io.Copy(f, br)
-	if err == nil {
-		b.fileSync = time.Now().UTC()
+// fileTime returns the modification time of the open file f, the current
+// time if it is unknown
+func fileTime(f *os.File) time.Time {
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Now()
 	}
-	return err
+	return fi.ModTime()
+}
+
+// save saves the buffer
+func (b *buffer) save() error {
+	return b.saveAs(b.filename)
 }
 
 // bufReader is used to implement the Reader interface and help copy
@@ -112,22 +139,35 @@ type bufReader struct {
 	buf  *buffer
 	line int
 	pos  int
+	rest []byte // the bytes of the last rune that did not fit in p
 }
 
 // Read implements the Reader interface for bufReader
 func (br *bufReader) Read(p []byte) (n int, err error) {
-	b := br.buf
-	for linenum, ln := range b.text[br.line:] {
-		for posnum, r := range ln[br.pos:] {
-			if len(p) < n+utf8.UTFMax {
-				br.line += linenum
-				br.pos += posnum
+	n = copy(p, br.rest)
+	br.rest = br.rest[n:]
+	t := br.buf.text
+	for ; br.line < t.len(); br.line++ {
+		ln := t.line(br.line)
+		for ; br.pos < len(ln); br.pos++ {
+			if n == len(p) {
 				return n, nil
 			}
-			n += utf8.EncodeRune(p[n:], r)
+			if len(p) < n+utf8.UTFMax {
+				// the rune may not fit, the bytes left go in the next Read
+				var rb [utf8.UTFMax]byte
+				size := utf8.EncodeRune(rb[:], ln[br.pos])
+				c := copy(p[n:], rb[:size])
+				n, br.rest = n+c, rb[c:size]
+				continue
+			}
+			n += utf8.EncodeRune(p[n:], ln[br.pos])
 		}
 		br.pos = 0
 	}
+	if len(br.rest) > 0 {
+		return n, nil
+	}
 	return n, io.EOF
 }
 
@@ -144,7 +184,7 @@ func (be *backend) openFile(b *buffer, name string) error {
 	fp := filename(name)
 	b.filename = fp
 	b.name = path.Base(fp)
-	b.filetype = filetypes[path.Ext(fp)]
+	b.setFiletype(detectFiletype(fp, nil))
 	errPrefix := "Hmpf, I cannot open the file '%v', "
 	f, err := os.Open(fp)
 	switch {
@@ -157,25 +197,27 @@ func (be *backend) openFile(b *buffer, name string) error {
 	}
 	defer f.Close()
 
-	sc := bufio.NewScanner(f)
-	b.text = b.text[:0]
-	b.mod = normalMode
-	for sc.Scan() {
-		b.text = append(b.text, []rune(sc.Text()+"\n"))
-	}
-	if err := sc.Err(); err != nil {
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
 		return fmt.Errorf(errPrefix+"got this error:\n%v\n", fp, err)
 	}
-	if len(b.text) == 0 {
-		b.text[0] = newLine()
+	b.setFiletype(detectFiletype(fp, bytes.SplitN(content, []byte("\n"), 2)[0]))
+	b.text = ropeFromBytes(content)
+	b.loadUndo(contentHash(content))
+	b.setSaved()
+	b.mod = normalMode
+	if len(content) == 0 {
 		b.mod = insertMode
 	}
-	b.fileSync = time.Now().UTC()
+	b.fileContent, b.fileSync = content, fileTime(f)
+	b.loadFileDiagnostics()
 	return nil
 }
 
+// CommandMode returns whether the user is typing in the message line, either
+// a command or a search
 func (be *backend) CommandMode() bool {
-	return be.commandMode
+	return be.commandMode || be.searchMode
 }
 
 func (be *backend) MsgLine() line {
@@ -1,20 +1,32 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // buffer is the representation of an open buffer
 type buffer struct {
-	text        text
-	marks       []mark
-	savedCursor mark // to save the cursor when the buffer has no view attached
+	text        *rope         // the buffer lines
+	id          int           // the number of the buffer in the buffer list
+	scratch     bool          // true for the lists and outputs not backed by a file
+	marks       map[rune]mark // named marks, set with :mark
+	savedCursor mark          // to save the cursor when the buffer has no view attached
 	mod         mode
 	name        string
 	filename    string
 	filetype    filetype
-	fileSync    time.Time
-	modified    bool       // true if not synched with file
-	changeList  changeList // for undo / redo (TODO make it file based)
-	lastInsert  insertText // text added in last insertMode session
+	tabStop     int             // the width of a tab and of a level of indentation
+	expandTab   bool            // true to indent with spaces instead of tabs
+	fileSync    time.Time       // the modification time of the file when read or saved
+	fileContent []byte          // the content of the file when read or saved
+	diskChange  time.Time       // the modification time of a change not loaded yet
+	modified    bool            // true if not synched with file
+	savedChange *undoNode       // the state of the changeList saved to file
+	changeList  changeList      // for undo / redo, saved in an undo file
+	lastInsert  insertText      // text added in last insertMode session
+	visual      visualSelection // the selection when in visualMode
+	diags       diagnosticStore // the problems found in the text
 }
 
 // insertText represents the change to the buffer's text since insertMode was
@@ -37,7 +49,7 @@ func newLine() line {
 	return ln
 }
 
-//mode represents an editing mode for the buffer
+// mode represents an editing mode for the buffer
 type mode int
 
 // the different modes for a buffer
@@ -48,20 +60,18 @@ const (
 	visualMode
 )
 
-// content returns the slice containing the buffer text lines
-func (b *buffer) content() []line {
-	return b.text
-}
-
 // insertChar inserts the passed in rune after the mark
 func (m mark) insertChar(ch rune) {
 	if ch == '\n' {
 		panic("Wrong function to insert newline")
 	}
 	b := m.buf
-	b.text[m.line] = append(b.text[m.line], 0)
-	copy(b.text[m.line][m.pos+1:], b.text[m.line][m.pos:])
-	b.text[m.line][m.pos] = ch
+	old := b.text.line(m.line)
+	ln := make(line, len(old)+1)
+	copy(ln, old[:m.pos])
+	ln[m.pos] = ch
+	copy(ln[m.pos+1:], old[m.pos:])
+	b.text.setLine(m.line, ln)
 
 	// add undo info
 	b.lastInsert.newText.appendChar(ch)
@@ -70,55 +80,78 @@ func (m mark) insertChar(ch rune) {
 // insertNewLineChar inserts a new line after the mark
 func (m mark) insertNewLineChar() {
 	b := m.buf
-	m.insertLineBelow()
-	b.text[m.line+1] = append(line(nil), b.text[m.line][m.pos:]...)
-	b.text[m.line] = append(b.text[m.line][:m.pos], '\n')
+	ln := b.text.line(m.line)
+	b.text.replace(m.line, m.line+1, append(ln[:m.pos:m.pos], '\n'),
+		append(line(nil), ln[m.pos:]...))
 
 	// add undo info
 	b.lastInsert.newText.appendChar('\n')
 }
 
 func (m mark) insertTab() {
-	for _, r := range tab {
+	for _, r := range m.buf.tab() {
 		m.insertChar(r)
 	}
 }
 
 // insertLineBelow inserts a line belor the mark
 func (m mark) insertLineBelow() {
-	b := m.buf
-	b.text = append(b.text, nil)
-	m2 := mark{m.line + 1, 0, m.buf}
-	copy(b.text[m2.line+1:], b.text[m2.line:])
-	b.text[m2.line] = newLine()
+	m.buf.text.insert(m.line+1, newLine())
+}
+
+// editInsert makes with edit a change to the text from fr to to, which can
+// lie outside the text inserted since insertMode was entered, and adds it to
+// the last insert: the change recorded grows to cover both. The text before
+// fr and after to must not be touched by edit
+func (b *buffer) editInsert(fr, to mark, edit func()) {
+	li := &b.lastInsert
+	start, end := *li.start, li.start.toEndofText(li.newText)
+	lo, hi := start, end
+	if fr.isBefore(lo) {
+		lo = fr
+	}
+	if hi.isBefore(to) {
+		hi = to
+	}
+	oldText := copyText(lo.copy(start))
+	oldText.appendText(li.oldText)
+	oldText.appendText(end.copy(hi))
+
+	// the text after hi does not move from the end of the text
+	linesAfter := b.text.len() - 1 - hi.line
+	charsAfter := len(b.text.line(hi.line)) - hi.pos
+	edit()
+	hi.line = b.text.len() - 1 - linesAfter
+	hi.pos = len(b.text.line(hi.line)) - charsAfter
+
+	li.start = &lo
+	li.oldText = oldText
+	li.newText = copyText(lo.copy(hi))
 }
 
 // deleteCharBackward deletes the character before the mark and returns
 // the new postion of the mark to be used to move the cursor if needed
 func (m mark) deleteCharBackward() mark {
-	b := m.buf
-	var deleted rune // for undo info
-
+	if m.atLineStart() && m.atFirstLine() {
+		return m
+	}
+	to := m
 	if m.atLineStart() {
-		if m.atFirstLine() {
-			return m
-		}
 		m.line -= 1
 		m.pos = m.lastCharPos() + 1
-		m.joinLineBelow()
-		deleted = '\n'
 	} else {
 		m.pos -= 1
-		deleted = m.char()
-		b.text[m.line] = append(b.text[m.line][:m.pos], b.text[m.line][m.pos+1:]...)
-	}
-
-	// add undo info
-	b.lastInsert.oldText.prependChar(deleted)
-	if m.isBefore(*b.lastInsert.start) {
-		b.lastInsert.start = &m
 	}
 
+	// add undo info; deleting text just inserted cancels it, whether typed or
+	// added as indentation
+	m.buf.editInsert(m, to, func() {
+		if to.line > m.line {
+			m.joinLineBelow()
+		} else {
+			m.deleteChar()
+		}
+	})
 	return m
 }
 
@@ -135,41 +168,42 @@ func (m mark) deleteCharForward() {
 		deleted = '\n'
 	} else {
 		deleted = m.char()
-		b.text[m.line] = append(b.text[m.line][:m.pos], b.text[m.line][m.pos+1:]...)
+		m.deleteChar()
 	}
 
 	// add undo info
 	b.lastInsert.oldText.appendChar(deleted)
 }
 
+// deleteChar deletes the character under the mark, which must not be a newline
+func (m mark) deleteChar() {
+	ln := m.buf.text.line(m.line)
+	m.buf.text.setLine(m.line, append(ln[:m.pos:m.pos], ln[m.pos+1:]...))
+}
+
 // joinLineBelow joins the mark's line with the line below
 func (m mark) joinLineBelow() {
 	if m.atLastLine() {
 		return
 	}
-	m.buf.text[m.line] = append(m.buf.text[m.line][:m.lastCharPos()+1],
-		m.buf.text[m.line+1]...)
-	mark{m.line + 1, 0, m.buf}.deleteLine()
+	t := m.buf.text
+	ln := t.line(m.line)
+	t.replace(m.line, m.line+2, append(ln[:m.lastCharPos()+1:m.lastCharPos()+1],
+		t.line(m.line+1)...))
 }
 
 // deleteLines deletes the mark's line
 func (m mark) deleteLine() {
-	b := m.buf
-	if len(b.text) == 1 {
-		b.text[0] = newLine()
-		return
-	}
-	b.text = append(b.text[:m.line], b.text[m.line+1:]...)
+	m.buf.text.delete(m.line, m.line+1)
 }
 
 // deleteLines deletes the lines between the two marks including marks' lines
 func (b *buffer) deleteLines(m1, m2 mark) int {
 	if m1.atFirstLine() && m2.atLastLine() {
-		b.text[0] = newLine()
-		b.text = b.text[:1]
+		b.text.delete(0, b.text.len())
 		return m2.line
 	}
-	b.text = append(b.text[:m1.line], b.text[m2.line+1:]...)
+	b.text.delete(m1.line, m2.line+1)
 	return m2.line - m1.line + 1
 }
 
@@ -177,22 +211,28 @@ func (b *buffer) deleteLines(m1, m2 mark) int {
 // to be used to position the cursor if needed
 func (r region) delete() mark {
 	var fr, to = orderMarks(r.start, r.end)
-	b := fr.buf
-	b.text[fr.line] = append(b.text[fr.line][:fr.pos], b.text[to.line][to.pos:]...)
-	if to.line > fr.line {
-		to.line -= b.deleteLines(mark{fr.line + 1, 0, b}, to)
-		if fr.atEmptyLine() && fr.maxLine() > 0 {
-			fr.deleteLine()
-		}
+	r.remove()
+	if to.line > fr.line && fr.atEmptyLine() && fr.maxLine() > 0 {
+		fr.deleteLine()
 	}
 	fr.fixPos()
 	return fr
 }
 
+// remove deletes exactly the text between the two region's marks, end mark
+// excluded, and returns the mark where the deleted text started
+func (r region) remove() mark {
+	var fr, to = orderMarks(r.start, r.end)
+	t := fr.buf.text
+	ln := t.line(fr.line)
+	t.replace(fr.line, to.line+1, append(ln[:fr.pos:fr.pos], t.line(to.line)[to.pos:]...))
+	return fr
+}
+
 // replace replaces the region with newText
 func (r region) replace(newText text) {
-	r.delete()
-	r.start.insertText(newText)
+	start := r.remove()
+	start.insertText(newText)
 }
 
 // insertText inserts the text at mark
@@ -203,41 +243,39 @@ func (m mark) insertText(text text) {
 
 	b := m.buf
 	if m.line > m.maxLine() {
-		b.text = append(b.text, line{})
+		b.text.insert(b.text.len(), line{})
+	}
+	old := b.text.line(m.line)
+	suffix := append(line{}, old[m.pos:]...)
+	lines := make([]line, 0, len(text)+1)
+	lines = append(lines, append(append(line{}, old[:m.pos]...), text[0]...))
+	for _, ln := range text[1:] {
+		lines = append(lines, append(line{}, ln...))
 	}
-	suffix := append(line{}, b.text[m.line][m.pos:]...)
-	b.text[m.line] = append(b.text[m.line][:m.pos], text[0]...)
-	seg1 := b.text[:m.line+1]
-	seg2 := text[1:]
-	seg3 := b.text[m.line+1:]
 	if len(suffix) > 0 {
-		switch {
-		case seg1.lastChar() != '\n':
-			seg1.appendChars(suffix)
-		case len(seg2) > 0 && seg2.lastChar() != '\n':
-			seg2.appendChars(suffix)
-		default:
-			seg3 = append([]line{suffix}, seg3...)
+		last := len(lines) - 1
+		// the suffix is joined to the last inserted line unless it ends
+		// with a newline
+		if lines[last].lastChar() != '\n' {
+			lines[last] = append(lines[last], suffix...)
+		} else {
+			lines = append(lines, suffix)
 		}
 	}
-	//debug.Printf("seg1 %q, len %v", seg1, len(seg1))
-	//debug.Printf("seg2 %q, len %v", seg2, len(seg2))
-	//debug.Printf("seg3 %q, len %v", seg3, len(seg3))
-	b.text = append(seg1, append(seg2, seg3...)...)
+	b.text.replace(m.line, m.line+1, lines...)
 }
 
 // copy copies and return the text between the two marks included
 func (from mark) copy(to mark) (text []line) {
 	start, end := orderMarks(from, to)
+	t := start.buf.text
 	if start.line == end.line {
-		text = append(text, start.buf.text[start.line][start.pos:end.pos])
+		text = append(text, t.line(start.line)[start.pos:end.pos])
 		return text
 	}
-	text = append(text, start.buf.text[start.line][start.pos:])
-	for i := start.line + 1; i < end.line; i++ {
-		text = append(text, start.buf.text[i])
-	}
-	text = append(text, start.buf.text[end.line][:end.pos])
+	text = append(text, t.line(start.line)[start.pos:])
+	text = append(text, t.lines(start.line+1, end.line)...)
+	text = append(text, t.line(end.line)[:end.pos])
 	return text
 }
 
@@ -300,32 +338,52 @@ func (m mark) enteringMode(mod mode) {
 	}
 }
 
-func (m mark) addUndoRedoLastInsert() {
-	if m.buf.lastInsert.newText.empty() && m.buf.lastInsert.oldText.empty() {
-		return
-	}
+// setSaved records that the text of b is the one of its file
+func (b *buffer) setSaved() {
+	b.changeList.init()
+	b.savedChange = b.changeList.current
+	b.modified = false
+}
 
-	start := *m.buf.lastInsert.start
-	end := mark{m.line, m.pos, m.buf}
-	undoCtx := undoContext{
-		text:  m.buf.lastInsert.oldText,
-		start: start,
-		end:   end,
+// updateModified sets the modified flag of b: its text differs from the one
+// saved if changes were made since, and not undone, or if text is being
+// inserted. Scratch buffers are never modified
+func (b *buffer) updateModified() {
+	li := b.lastInsert
+	b.modified = !b.scratch && (b.changeList.current != b.savedChange ||
+		b.mod == insertMode && !(li.newText.empty() && li.oldText.empty()))
+}
+
+// updateModifiedFlags updates the modified flag of all the buffers
+func updateModifiedFlags() {
+	for _, b := range be.bufs {
+		b.updateModified()
 	}
-	undoEnd := start.toEndofText(m.buf.lastInsert.oldText)
-	regF := func(m mark) (region, direction) {
-		return region{start: start,
-			end: undoEnd}, right
+}
+
+// modifiedBuffer returns the first modified buffer of the buffer list, nil
+// if there is none
+func modifiedBuffer() *buffer {
+	for _, b := range listedBuffers() {
+		if b.modified {
+			return b
+		}
 	}
-	redoCtx := &cmdContext{
-		num:      1,
-		cmd:      replace,
-		point:    m.buf.lastInsert.start,
-		text:     m.buf.lastInsert.newText,
-		reg:      regF,
-		cmdChans: cmdStack{commands, make(chan struct{}, 1)},
+	return nil
+}
+
+// unsavedMessage is the message refusing to drop the changes to b
+func unsavedMessage(b *buffer) string {
+	return fmt.Sprintf("No write since last change for buffer %v %q (add ! to override)",
+		b.id, b.name)
+}
+
+func (m mark) addUndoRedoLastInsert() {
+	li := m.buf.lastInsert
+	if li.newText.empty() && li.oldText.empty() {
+		return
 	}
-	m.buf.changeList.add(newRedoCtx(redoCtx), undoCtx)
+	m.buf.changeList.addReplace(*li.start, li.oldText, li.newText)
 }
 
 func textToString(t []line) string {
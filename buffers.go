@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	commandModeFuncs["ls"] = listBuffers
+	commandModeFuncs["buffers"] = listBuffers
+	commandModeFuncs["b"] = toBuffer
+	commandModeFuncs["buffer"] = toBuffer
+	commandModeFuncs["bn"] = nextBuffer
+	commandModeFuncs["bnext"] = nextBuffer
+	commandModeFuncs["bp"] = prevBuffer
+	commandModeFuncs["bprevious"] = prevBuffer
+	commandModeFuncs["bd"] = deleteBuffer(false)
+	commandModeFuncs["bdelete"] = deleteBuffer(false)
+	commandModeFuncs["bd!"] = deleteBuffer(true)
+	commandModeFuncs["bdelete!"] = deleteBuffer(true)
+	cmdKeyNormalMode[KeyCtrl6] = command{toAlternateBuffer, nil}
+}
+
+// showBuffer shows buffer b in view v, with the cursor b had when it was
+// last shown
+func (v *view) showBuffer(b *buffer) {
+	if b == v.buf {
+		return
+	}
+	cs := b.savedCursor
+	cs.buf = b
+	v.moveTo(cs)
+	v.cs.fixLineAndPos()
+}
+
+// bufferInfo describes the buffer shown in v for the message line
+func bufferInfo(v *view) string {
+	return fmt.Sprintf("%v: %v lines", v.buf.name, v.buf.text.len())
+}
+
+// listedBuffers returns the buffers of the buffer list, in the order they
+// were opened, leaving out the scratch buffers
+func listedBuffers() []*buffer {
+	var bufs []*buffer
+	for _, b := range be.bufs {
+		if !b.scratch {
+			bufs = append(bufs, b)
+		}
+	}
+	return bufs
+}
+
+// findListedBuffer returns the buffer of the buffer list numbered arg, or
+// else the only one whose name contains arg
+func findListedBuffer(arg string) (*buffer, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("Usage: b {number|name}")
+	}
+	bufs := listedBuffers()
+	if id, err := strconv.Atoi(arg); err == nil {
+		for _, b := range bufs {
+			if b.id == id {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("No buffer %v", id)
+	}
+	var matches []*buffer
+	for _, b := range bufs {
+		switch {
+		case b.name == arg:
+			return b, nil
+		case strings.Contains(b.name, arg):
+			matches = append(matches, b)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No matching buffer for %v", arg)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("More than one match for %v", arg)
+}
+
+// listBuffers returns the buffer list: the number of each buffer, followed
+// by % for the buffer of v, # for the alternate buffer and + if it is
+// modified, then its name and its cursor line
+func listBuffers(v *view, args []string) (msg string) {
+	var entries []string
+	for _, b := range listedBuffers() {
+		flags, ln := "", b.savedCursor.line
+		switch b {
+		case v.buf:
+			flags, ln = "%", v.cs.line
+		case be.alternate:
+			flags = "#"
+		}
+		if b.modified {
+			flags += "+"
+		}
+		entry := []string{strconv.Itoa(b.id)}
+		if flags != "" {
+			entry = append(entry, flags)
+		}
+		entry = append(entry, strconv.Quote(b.name), fmt.Sprintf("line %v", ln+1))
+		entries = append(entries, strings.Join(entry, " "))
+	}
+	return strings.Join(entries, " | ")
+}
+
+// toBuffer shows in v the buffer given by number or by name in args
+func toBuffer(v *view, args []string) (msg string) {
+	b, err := findListedBuffer(strings.TrimSpace(strings.Join(args, " ")))
+	if err != nil {
+		return err.Error()
+	}
+	v.showBuffer(b)
+	return bufferInfo(v)
+}
+
+func nextBuffer(v *view, args []string) (msg string) {
+	return cycleBuffers(v, 1)
+}
+
+func prevBuffer(v *view, args []string) (msg string) {
+	return cycleBuffers(v, -1)
+}
+
+// cycleBuffers shows in v the buffer step places after the buffer of v in
+// the buffer list, wrapping around its ends
+func cycleBuffers(v *view, step int) (msg string) {
+	bufs := listedBuffers()
+	if len(bufs) == 0 {
+		return "No buffers"
+	}
+	i := 0
+	for j, b := range bufs {
+		if b == v.buf {
+			i = j + step
+		}
+	}
+	v.showBuffer(bufs[(i%len(bufs)+len(bufs))%len(bufs)])
+	return bufferInfo(v)
+}
+
+// deleteBuffer returns the command removing from the buffer list the buffer
+// given by number or by name in args, the buffer of v by default; a modified
+// buffer is only removed if force is true. The view showing the buffer
+// switches to the alternate buffer, or to the first one of the list
+func deleteBuffer(force bool) commandModeF {
+	return func(v *view, args []string) (msg string) {
+		b := v.buf
+		if arg := strings.TrimSpace(strings.Join(args, " ")); arg != "" {
+			var err error
+			if b, err = findListedBuffer(arg); err != nil {
+				return err.Error()
+			}
+		}
+		if b.modified && !force {
+			return unsavedMessage(b)
+		}
+		be.removeBuffer(b)
+		if v.buf == b {
+			next := be.alternate
+			if bufs := listedBuffers(); next == nil && len(bufs) > 0 {
+				next = bufs[0]
+			}
+			if next == nil {
+				next = be.newBuffer("")
+			}
+			v.showBuffer(next)
+			be.alternate = nil
+		}
+		return fmt.Sprintf("%v deleted", b.name)
+	}
+}
+
+// removeBuffer removes buffer b from the buffers of the backend
+func (be *backend) removeBuffer(b *buffer) {
+	for i, b2 := range be.bufs {
+		if b2 == b {
+			be.bufs = append(be.bufs[:i], be.bufs[i+1:]...)
+			break
+		}
+	}
+	if be.alternate == b {
+		be.alternate = nil
+	}
+}
+
+// toAlternateBuffer shows the alternate buffer, the one shown before the
+// current one
+func toAlternateBuffer(ctx *cmdContext) {
+	b := be.alternate
+	if b == nil {
+		ctx.msg = "No alternate buffer"
+		return
+	}
+	ctx.view.showBuffer(b)
+	ctx.msg = bufferInfo(ctx.view)
+}
@@ -15,8 +15,8 @@ var commandModeKeyTable = map[Key]func(){
 	KeyTab:        nil,
 	KeyDelete:     nil,
 	KeySpace:      func() { be.msgLine = append(be.msgLine, ' ') },
-	KeyBackspace:  cmdModeBackSpace,
-	KeyBackspace2: cmdModeBackSpace,
+	KeyBackspace:  func() { r.commands.backSpace() },
+	KeyBackspace2: func() { r.commands.backSpace() },
 }
 
 type commandModeF func(v *view, args []string) (msg string)
@@ -25,6 +25,7 @@ type commandRegister struct {
 	list    []line // the list of commands
 	last    int    // to retrieve past commands
 	current line   // the current command being typed
+	prompt  string // the prompt shown in the message line before the command
 }
 
 func (c *commandRegister) reset() {
@@ -43,10 +44,12 @@ func (c *commandRegister) add(cmd line) {
 }
 
 func (c *commandRegister) previous() {
+	if len(c.list) == 0 {
+		return
+	}
 	for found := false; found == false; {
-		debug.Println(c)
 		if c.list[c.last].hasPrefix(c.current) {
-			be.msgLine = append(be.msgLine[:len(commandModePrompt)],
+			be.msgLine = append(be.msgLine[:len(c.prompt)],
 				c.list[c.last]...)
 			found = true
 		}
@@ -61,12 +64,12 @@ func (c *commandRegister) previous() {
 func (c *commandRegister) next() {
 	for {
 		if c.last == len(c.list)-1 {
-			be.msgLine = append(be.msgLine[:len(commandModePrompt)], c.current...)
+			be.msgLine = append(be.msgLine[:len(c.prompt)], c.current...)
 			return
 		}
 		c.last++
 		if c.list[c.last].hasPrefix(c.current) {
-			be.msgLine = append(be.msgLine[:len(commandModePrompt)],
+			be.msgLine = append(be.msgLine[:len(c.prompt)],
 				c.list[c.last]...)
 			return
 		}
@@ -74,16 +77,35 @@ func (c *commandRegister) next() {
 }
 
 var commandModeFuncs = map[string]commandModeF{
-	"q":    quit,
-	"echo": echo,
+	"q":        quit,
+	"q!":       forceQuit,
+	"qa":       forceQuit,
+	"qa!":      forceQuit,
+	"qall":     forceQuit,
+	"echo":     echo,
+	"noh":      noHighlight,
+	"mark":     setMark,
+	"k":        setMark,
+	"earlier":  earlier,
+	"later":    later,
+	"undolist": undoList,
 }
 
 func echo(v *view, args []string) (msg string) {
 	return strings.Join(args, " ")
 }
 
+// quit quits unless a buffer of the buffer list has changes not saved
 func quit(v *view, args []string) (msg string) {
-	exitProgram(nil)
+	if b := modifiedBuffer(); b != nil {
+		return unsavedMessage(b)
+	}
+	return forceQuit(v, args)
+}
+
+// forceQuit quits dropping the changes not saved
+func forceQuit(v *view, args []string) (msg string) {
+	exit <- true
 	return "Bye-bye"
 }
 
@@ -98,8 +120,7 @@ func enterCommandMode(ctx *cmdContext) {
 }
 
 func exitCommandMode() {
-	r.commands.last = len(r.commands.list) - 1
-	r.commands.current = r.commands.current[:0]
+	r.commands.exit()
 	be.commandMode = false
 	ui.Draw()
 }
@@ -109,20 +130,32 @@ func enterCommand(v *view, cmd line) (msg string) {
 		return ""
 	}
 	r.commands.add(cmd)
-	tokens := strings.Split(string(cmd), " ")
-	c, args := tokens[0], tokens[1:]
-	f := commandModeFuncs[c]
-	if f == nil {
-		return "Unknown command: " + string(cmd)
+	rg, rest, err := parseRange(*v.cs, string(cmd))
+	if err != nil {
+		return err.Error()
+	}
+	name := commandName(rest)
+	if rg.from < 0 && !zeroAddressCommands[name] {
+		return errInvalidRange.Error()
+	}
+	if f := rangeCommandFuncs[name]; f != nil {
+		msg = f(v, rg, strings.TrimSpace(rest[len(name):]))
+	} else {
+		tokens := strings.Split(rest, " ")
+		c, args := tokens[0], tokens[1:]
+		f := commandModeFuncs[c]
+		switch {
+		case f == nil:
+			return "Unknown command: " + string(cmd)
+		case rg.set:
+			return "No range allowed: " + string(cmd)
+		}
+		msg = f(v, args)
 	}
-	msg = f(v, args)
 
 	// make sure the cursor is valid in case the command changed the buffer
-	cs := ui.CurrentView().cs
-	if cs.line > len(ui.CurrentView().buf.text)-1 {
-		cs.line = len(ui.CurrentView().buf.text) - 1
-	}
-	cs.fixPos()
+	v.cs.fixLineAndPos()
+	updateModifiedFlags()
 
 	return msg
 }
@@ -136,7 +169,7 @@ func parseCommandMode(ev *UIEvent, ctx *cmdContext) (
 		switch ev.Key.Special {
 		case KeyCtrlJ, KeyEnter:
 			be.msgLine = stringToLine(
-				enterCommand(ctx.view, be.msgLine[len(commandModePrompt):]))
+				enterCommand(ev.View, be.msgLine[len(commandModePrompt):]))
 			exitCommandMode()
 			return nil, false
 		case KeyEsc, KeyCtrlC:
@@ -154,9 +187,16 @@ func parseCommandMode(ev *UIEvent, ctx *cmdContext) (
 	return parseCommandMode, false
 }
 
-func cmdModeBackSpace() {
-	if len(be.msgLine) > len(commandModePrompt) {
+// backSpace deletes the last char of the command being typed
+func (c *commandRegister) backSpace() {
+	if len(be.msgLine) > len(c.prompt) {
 		be.msgLine = be.msgLine[:len(be.msgLine)-1]
-		r.commands.current = append(line{}, be.msgLine[len(commandModePrompt):]...)
+		c.current = append(line{}, be.msgLine[len(c.prompt):]...)
 	}
 }
+
+// exit resets the register to be ready for the next command
+func (c *commandRegister) exit() {
+	c.last = len(c.list) - 1
+	c.current = c.current[:0]
+}
@@ -1,5 +1,7 @@
 package main
 
+import "unicode"
+
 type direction int
 
 const (
@@ -22,6 +24,7 @@ type cmdContext struct {
 	customList []string   // optional string slice object
 	text       []line     // optional text object
 	silent     bool       // if true does not redraw the screen after execution
+	register   rune       // optional register name for yank, delete and put
 	msg        string     // to comunicate back to user
 	cmdChans   cmdStack   // channels to push the command and wait for done signal
 }
@@ -33,8 +36,16 @@ type command struct {
 type cmdFunc func(ctx *cmdContext)
 type parseFunc func(ev *UIEvent, ctx *cmdContext) (parseFunc, bool)
 
-var cmdStringTables = [2]map[string]command{cmdStringInsertMode, cmdStringNormalMode}
-var cmdKeyTables = [2]map[Key]command{cmdKeyInsertMode, cmdKeyNormalMode}
+var cmdStringTables = [...]map[string]command{
+	insertMode: cmdStringInsertMode,
+	normalMode: cmdStringNormalMode,
+	visualMode: cmdStringVisualMode,
+}
+var cmdKeyTables = [...]map[Key]command{
+	insertMode: cmdKeyInsertMode,
+	normalMode: cmdKeyNormalMode,
+	visualMode: cmdKeyVisualMode,
+}
 
 func lookupStringCmd(m mode, s string) command {
 	return cmdStringTables[m][s]
@@ -52,6 +63,7 @@ var cmdKeyNormalMode = map[Key]command{
 	KeyCtrlK: command{toUpPane, nil},
 	KeyCtrlJ: command{toDownPane, nil},
 	KeyCtrlL: command{toRightPane, nil},
+	KeyCtrlV: command{enterVisualBlockMode, nil},
 }
 
 // commands should be at most two chars to avoid risk of over-shadowing one char
@@ -67,6 +79,14 @@ var cmdStringNormalMode = map[string]command{
 	"l":  command{moveCursorRight, nil},
 	"d":  command{delete_, parseRegion},
 	"dd": command{deleteLine, nil},
+	"c":  command{change, parseRegion},
+	"cc": command{changeLine, nil},
+	"C":  command{changeToEndOfLine, nil},
+	"s":  command{changeChars, nil},
+	"y":  command{yank, parseRegion},
+	"yy": command{yankLine, nil},
+	"p":  command{putAfter, nil},
+	"P":  command{putBefore, nil},
 	"x":  command{deleteCharForward, nil},
 	"e":  command{moveCursorTo, nil},
 	"E":  command{moveCursorTo, nil},
@@ -80,15 +100,23 @@ var cmdStringNormalMode = map[string]command{
 	"L":  command{moveCursorTo, nil},
 	"gg": command{moveCursorTo, nil},
 	"G":  command{moveCursorTo, nil},
+	"n":  command{moveCursorTo, nil},
+	"N":  command{moveCursorTo, nil},
+	"/":  command{searchForward, nil},
+	"?":  command{searchBackward, nil},
 	"m":  command{recordMacro, nil},
 	"u":  command{undo, nil},
-	//TODO make = a command accepting object
+	"g-": command{undoTime, nil},
+	"g+": command{undoTime, nil},
+	".":  command{repeatLastChange, nil},
+	"=":  command{indentRegion, parseRegion},
 	"==": command{indent, nil},
 	";":  command{enterCommandMode, nil},
 	":":  command{enterCommandMode, nil},
-	"sv": command{splitVertical, nil},
-	"sh": command{splitHorizontal, nil},
-	//"p":  command{paste, nil},
+	",v": command{splitVertical, nil},
+	",s": command{splitHorizontal, nil},
+	"v":  command{enterVisualMode, nil},
+	"V":  command{enterVisualLineMode, nil},
 }
 
 var cmdKeyInsertMode = map[Key]command{
@@ -163,6 +191,46 @@ func moveCursorTo(ctx *cmdContext) {
 	}
 }
 
+// exclusiveMotions are the motions whose end char is never part of the region
+// they give to an operator
+var exclusiveMotions = map[string]bool{
+	"n": true,
+	"N": true,
+	"/": true,
+	"?": true,
+}
+
+// operatorRegion adjusts the region r returned by the regionFunc named arg
+// to include the end char, as operators expect, for motions moving right
+// unless they move to the start of the next word or to a search match
+func operatorRegion(r region, dir direction, arg string) region {
+	if exclusiveMotions[arg] {
+		return r
+	}
+	if dir == right && !r.end.atLineEnd() &&
+		!((arg == "W" || arg == "w") && !r.end.atLastTextChar()) {
+		r.end.pos++
+	}
+	return r
+}
+
+// motionRegion returns the region going from the cursor to where ctx.num
+// repetitions of the regionFunc ctx.reg get, adjusted for operators, or the
+// region of the text objects around the cursor
+func motionRegion(ctx *cmdContext) region {
+	if textObjects[ctx.argString] != nil {
+		return objectRegion(ctx)
+	}
+	m := *ctx.point
+	var reg region
+	var dir direction
+	for i := 0; i < ctx.num; i++ {
+		reg, dir = ctx.reg(m)
+		m = reg.end
+	}
+	return operatorRegion(region{*ctx.point, m}, dir, ctx.argString)
+}
+
 func delete_(ctx *cmdContext) {
 	switch ctx.argString {
 	case "gg":
@@ -170,14 +238,106 @@ func delete_(ctx *cmdContext) {
 	case "G":
 		deleteToEnd(ctx)
 	default:
-		for i := 0; i < ctx.num; i++ {
-			r, dir := ctx.reg(*ctx.point)
-			if dir == right && !r.end.atLineEnd() &&
-				!((ctx.argString == "W" || ctx.argString == "w") && !r.end.atLastTextChar()) {
-				r.end.pos++
+		reg := motionRegion(ctx)
+		fr, to := orderMarks(reg.start, reg.end)
+		t, linewise := regionText(ctx, reg)
+		r.texts.delete(ctx.register, t, linewise)
+		done := fr.buf.recordLinesChange(fr.line, to.line)
+		if textObjects[ctx.argString] != nil {
+			*ctx.point = reg.remove()
+			ctx.point.fixLineAndPos()
+		} else {
+			*ctx.point = reg.delete()
+		}
+		done()
+	}
+}
+
+// change deletes the region given by ctx.reg and enters insertMode; like in
+// vi cw and cW change to the end of the word
+func change(ctx *cmdContext) {
+	p := ctx.point
+	switch {
+	case ctx.argString == "gg":
+		changeLines(ctx, 0, p.line)
+	case ctx.argString == "G":
+		changeLines(ctx, p.line, p.lastLine())
+	case linewiseObjects[ctx.argString] != nil:
+		l1, l2 := objectLines(ctx)
+		if l2 < l1 {
+			return
+		}
+		changeLines(ctx, l1, l2)
+	default:
+		c := *ctx
+		if !unicode.IsSpace(p.char()) {
+			switch c.argString {
+			case "w":
+				c.argString, c.reg = "e", toWordEnd
+			case "W":
+				c.argString, c.reg = "E", toWORDEnd
 			}
-			*ctx.point = r.delete()
 		}
+		reg := motionRegion(&c)
+		fr, to := orderMarks(reg.start, reg.end)
+		r.texts.delete(ctx.register, fr.copy(to), false)
+		changeRegion(ctx, reg)
+	}
+}
+
+// changeLine changes ctx.num lines keeping the indentation of the first one
+func changeLine(ctx *cmdContext) {
+	to := ctx.point.line + ctx.num - 1
+	if to > ctx.point.maxLine() {
+		to = ctx.point.maxLine()
+	}
+	changeLines(ctx, ctx.point.line, to)
+}
+
+// changeToEndOfLine changes the text up to the end of the line, and of the
+// ctx.num-1 lines below
+func changeToEndOfLine(ctx *cmdContext) {
+	p := ctx.point
+	end := mark{p.line + ctx.num - 1, 0, p.buf}
+	if end.line > p.maxLine() {
+		end.line = p.maxLine()
+	}
+	end.pos = end.lineEndPos()
+	r.texts.delete(ctx.register, p.copy(end), false)
+	changeRegion(ctx, region{*p, end})
+}
+
+// changeChars changes ctx.num chars in the line
+func changeChars(ctx *cmdContext) {
+	p := ctx.point
+	end := mark{p.line, p.pos + ctx.num, p.buf}
+	if max := end.lineEndPos(); end.pos > max {
+		end.pos = max
+	}
+	r.texts.delete(ctx.register, p.copy(end), false)
+	changeRegion(ctx, region{*p, end})
+}
+
+// changeLines changes the lines from l1 to l2, keeping the indentation of l1
+func changeLines(ctx *cmdContext, l1, l2 int) {
+	b := ctx.point.buf
+	r.texts.delete(ctx.register, b.text.lines(l1, l2+1), true)
+	start, end := mark{l1, 0, b}, mark{l2, 0, b}
+	_, start.pos = lineIndent(b, l1)
+	end.pos = end.lineEndPos()
+	changeRegion(ctx, region{start, end})
+}
+
+// changeRegion removes the region reg and enters insertMode at its start.
+// The removed text is recorded as replaced by the text to be inserted, so
+// that the whole change is a single undo step
+func changeRegion(ctx *cmdContext, reg region) {
+	fr, to := orderMarks(reg.start, reg.end)
+	old := copyText(fr.copy(to))
+	*ctx.point = reg.remove()
+	ctx.point.setMode(insertMode)(ctx.point)
+	if !old.empty() {
+		ctx.point.buf.lastInsert.oldText = old
 	}
 }
 
@@ -188,11 +348,8 @@ func deleteLine(ctx *cmdContext) {
 		toline = p.maxLine()
 	}
 
-	// add undo info
-	start := mark{p.line, 0, p.buf}
-	text := text{append(line{}, p.buf.text[p.line]...)}
-	p.buf.changeList.add(*ctx, undoContext{text, start, mark{}})
-
+	r.texts.delete(ctx.register, p.buf.text.lines(p.line, toline+1), true)
+	defer p.buf.recordLinesChange(p.line, toline)()
 	p.buf.deleteLines(*p, mark{toline, 0, p.buf})
 	if p.line > p.maxLine() {
 		p.line--
@@ -202,12 +359,16 @@ func deleteLine(ctx *cmdContext) {
 
 func deleteToStart(ctx *cmdContext) {
 	b := ctx.point.buf
+	r.texts.delete(ctx.register, b.text.lines(0, ctx.point.line+1), true)
+	defer b.recordLinesChange(0, ctx.point.line)()
 	b.deleteLines(mark{0, 0, b}, *ctx.point)
 	*ctx.point = mark{0, 0, b}
 }
 
 func deleteToEnd(ctx *cmdContext) {
 	b := ctx.point.buf
+	r.texts.delete(ctx.register, b.text.lines(ctx.point.line, b.text.len()), true)
+	defer b.recordLinesChange(ctx.point.line, ctx.point.lastLine())()
 	b.deleteLines(*ctx.point, mark{ctx.point.lastLine(), 0, b})
 	*ctx.point = mark{ctx.point.line - 1, 0, b}
 	if ctx.point.line < 0 {
@@ -216,10 +377,18 @@ func deleteToEnd(ctx *cmdContext) {
 }
 
 func exitProgram(ctx *cmdContext) {
-	exit <- true
+	ctx.msg = quit(ctx.view, nil)
 }
 
 func deleteCharForward(ctx *cmdContext) {
+	if ctx.point.buf.mod == normalMode {
+		p := ctx.point
+		to := p.line + ctx.num
+		if to > p.maxLine() {
+			to = p.maxLine()
+		}
+		defer p.buf.recordLinesChange(p.line, to)()
+	}
 	for i := 0; i < ctx.num; i++ {
 		ctx.point.deleteCharForward()
 		ctx.point.fixPos()
@@ -243,45 +412,148 @@ func insertSpace(ctx *cmdContext) {
 func insertNewLine(ctx *cmdContext) {
 	ctx.point.insertNewLineChar()
 	ctx.point.set(ctx.point.line+1, 0)
-	ctx.point.pos += ctx.point.indentLine()
+	ctx.point.pos += ctx.point.indentInsertedLine()
 }
 
 func insertChar(ctx *cmdContext) {
 	ctx.point.insertChar(ctx.char)
 	ctx.point.moveRight(1)
 	if isIndentKey(ctx.char, ctx.point.buf) {
-		ctx.point.pos += ctx.point.indentLine()
+		ctx.point.pos += ctx.point.indentInsertedLine()
 	}
 }
 
 func saveToFile(ctx *cmdContext) {
-	for _, h := range beforeSaveHooks[anyFiletype] {
-		h(ctx.view)
+	ctx.msg, _ = saveBuffer(ctx.view)
+}
+
+func yank(ctx *cmdContext) {
+	b := ctx.point.buf
+	switch ctx.argString {
+	case "gg":
+		r.texts.yank(ctx.register, b.text.lines(0, ctx.point.line+1), true)
+		*ctx.point = mark{0, 0, b}
+	case "G":
+		r.texts.yank(ctx.register, b.text.lines(ctx.point.line, b.text.len()), true)
+	default:
+		reg := motionRegion(ctx)
+		start, _ := orderMarks(reg.start, reg.end)
+		t, linewise := regionText(ctx, reg)
+		r.texts.yank(ctx.register, t, linewise)
+		*ctx.point = start
+	}
+}
+
+func yankLine(ctx *cmdContext) {
+	p := ctx.point
+	toline := p.line + ctx.num - 1
+	if toline > p.maxLine() {
+		toline = p.maxLine()
+	}
+	r.texts.yank(ctx.register, p.buf.text.lines(p.line, toline+1), true)
+}
+
+func putAfter(ctx *cmdContext) {
+	put(ctx, true)
+}
+
+func putBefore(ctx *cmdContext) {
+	put(ctx, false)
+}
+
+// put inserts ctx.num times the text in register ctx.register after (or
+// before) the cursor, or below (or above) the cursor line for linewise text
+func put(ctx *cmdContext, after bool) {
+	p := ctx.point
+	reg := r.texts.get(ctx.register, p.buf)
+	if reg.text.empty() {
+		ctx.msg = "Nothing in register " + string(registerName(ctx.register))
+		return
+	}
+	t := text{line{}}
+	for i := 0; i < ctx.num; i++ {
+		t.appendText(reg.text)
 	}
-	for _, h := range beforeSaveHooks[ctx.view.buf.filetype] {
-		h(ctx.view)
+	var start mark
+	switch {
+	case reg.linewise && after:
+		// we insert the lines after the newline of the cursor line, that
+		// is we move the newline at the end of the inserted text
+		start = mark{p.line, p.lineEndPos(), p.buf}
+		t = append(text{line{'\n'}}, t...)
+		last := t[len(t)-1]
+		t[len(t)-1] = last[:len(last)-1]
+	case reg.linewise:
+		start = mark{p.line, 0, p.buf}
+	case after && !p.atEmptyLine():
+		start = mark{p.line, p.pos + 1, p.buf}
+	default:
+		start = *p
 	}
+	start.insertText(t)
+	p.buf.changeList.addReplace(start, nil, t)
 
-	err := ctx.point.buf.save()
-	if err != nil {
-		ctx.msg = err.Error()
+	if reg.linewise {
+		line := p.line
+		if after {
+			line++
+		}
+		*p = mark{line, 0, p.buf}
+		_, p.pos = lineIndent(p.buf, line)
 	} else {
-		ctx.msg = "file saved"
+		*p = start.toEndofText(t)
+		p.moveLeft(1)
 	}
 }
 
-func replace(ctx *cmdContext) {
-	r, _ := ctx.reg(mark{})
-	r.replace(ctx.text)
+func registerName(ch rune) rune {
+	if ch == 0 {
+		return unnamedRegister
+	}
+	return ch
 }
 
-func paste(ctx *cmdContext) {
-	ctx.point.insertText(ctx.text)
+// indent re-indents ctx.num lines from the cursor
+func indent(ctx *cmdContext) {
+	p := ctx.point
+	to := p.line + ctx.num - 1
+	if to > p.maxLine() {
+		to = p.maxLine()
+	}
+	p.buf.indentLines(p.line, to)
+	_, p.pos = lineIndent(p.buf, p.line)
+	p.fixPos()
 }
 
-func indent(ctx *cmdContext) {
-	ctx.point.pos += ctx.point.indentLine()
+// indentRegion re-indents the lines of the region given by the motion or
+// the text object, leaving the cursor on the first one
+func indentRegion(ctx *cmdContext) {
+	p := ctx.point
+	l1, l2 := operatorLines(ctx)
+	if l2 < l1 {
+		return
+	}
+	p.buf.indentLines(l1, l2)
+	*p = mark{l1, 0, p.buf}
+	_, p.pos = lineIndent(p.buf, l1)
+	p.fixPos()
+}
 
+// operatorLines returns the first and last line of the region given by the
+// motion or the text object of a linewise operator
+func operatorLines(ctx *cmdContext) (l1, l2 int) {
+	p := ctx.point
+	switch {
+	case ctx.argString == "gg":
+		return 0, p.line
+	case ctx.argString == "G":
+		return p.line, p.lastLine()
+	case linewiseObjects[ctx.argString] != nil:
+		return objectLines(ctx)
+	}
+	reg := motionRegion(ctx)
+	fr, to := orderMarks(reg.start, reg.end)
+	return fr.line, to.line
 }
 
 func splitVertical(ctx *cmdContext) {
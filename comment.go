@@ -0,0 +1,99 @@
+package main
+
+import "strings"
+
+func init() {
+	cmdStringNormalMode["gc"] = command{toggleComment, parseRegion}
+	cmdStringNormalMode["gcc"] = command{toggleCommentLine, nil}
+	cmdStringVisualMode["gc"] = command{visualToggleComment, nil}
+}
+
+// toggleComment comments the lines of the region given by the motion or the
+// text object, or uncomments them if they are all comments
+func toggleComment(ctx *cmdContext) {
+	l1, l2 := operatorLines(ctx)
+	if l2 < l1 {
+		return
+	}
+	ctx.msg = ctx.point.buf.toggleComments(l1, l2)
+	*ctx.point = mark{l1, 0, ctx.point.buf}
+	_, ctx.point.pos = lineIndent(ctx.point.buf, l1)
+	ctx.point.fixPos()
+}
+
+// toggleCommentLine toggles the comments of ctx.num lines from the cursor
+func toggleCommentLine(ctx *cmdContext) {
+	p := ctx.point
+	to := p.line + ctx.num - 1
+	if to > p.maxLine() {
+		to = p.maxLine()
+	}
+	ctx.msg = p.buf.toggleComments(p.line, to)
+	p.fixPos()
+}
+
+func visualToggleComment(ctx *cmdContext) {
+	b := ctx.point.buf
+	fr, to := b.selectedLines(*ctx.point)
+	ctx.msg = b.toggleComments(fr, to)
+	*ctx.point = mark{fr, 0, b}
+	_, ctx.point.pos = lineIndent(b, fr)
+	exitVisualMode(ctx)
+}
+
+// toggleComments uncomments the lines from l1 to l2 included if they are all
+// comments, else it comments them with the markers aligned after the
+// indentation they have in common, as one change; blank lines are left alone.
+// It returns a message for the file types without line comments
+func (b *buffer) toggleComments(l1, l2 int) (msg string) {
+	marker := b.filetype.info().comment
+	if marker == "" {
+		return "No line comments in " + b.filetype.info().name
+	}
+	var lines []string
+	commented, first := true, true
+	prefix := ""
+	for _, ln := range b.text.lines(l1, l2+1) {
+		s := strings.TrimSuffix(string(ln), "\n")
+		lines = append(lines, s)
+		body := strings.TrimLeft(s, " \t")
+		if body == "" {
+			continue
+		}
+		indent := s[:len(s)-len(body)]
+		if !strings.HasPrefix(body, marker) {
+			commented = false
+		}
+		if first {
+			prefix, first = indent, false
+		} else {
+			prefix = commonPrefix(prefix, indent)
+		}
+	}
+	defer b.recordLinesChange(l1, l2)()
+	for i, s := range lines {
+		body := strings.TrimLeft(s, " \t")
+		if body == "" {
+			continue
+		}
+		indent := s[:len(s)-len(body)]
+		if commented {
+			body = strings.TrimPrefix(body, marker)
+			body = strings.TrimPrefix(body, " ")
+			s = indent + body
+		} else {
+			s = s[:len(prefix)] + marker + " " + s[len(prefix):]
+		}
+		b.text.setLine(l1+i, stringToLine(s+"\n"))
+	}
+	return ""
+}
+
+// commonPrefix returns the longest common prefix of a and b
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+func init() {
+	cmdStringNormalMode["]d"] = command{nextDiagnostic, nil}
+	cmdStringNormalMode["[d"] = command{nextDiagnostic, nil}
+	commandModeFuncs["build"] = goBuild
+	commandModeFuncs["vet"] = goVet
+}
+
+// severity is the severity of a diagnostic, with the values of the language
+// server protocol
+type severity int
+
+const (
+	sevError severity = iota + 1
+	sevWarning
+	sevInfo
+	sevHint
+)
+
+var severityNames = map[severity]string{
+	sevError:   "error",
+	sevWarning: "warning",
+	sevInfo:    "info",
+	sevHint:    "hint",
+}
+
+// sign returns the char shown in the gutter for a diagnostic
+func (s severity) sign() rune {
+	switch s {
+	case sevError:
+		return 'E'
+	case sevWarning:
+		return 'W'
+	case sevInfo:
+		return 'I'
+	}
+	return 'H'
+}
+
+// diagnostic is a problem in the text of a buffer, from a compiler, a linter
+// or a language server
+type diagnostic struct {
+	start, end mark
+	severity   severity
+	msg        string
+}
+
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%v: %v", severityNames[d.severity], d.msg)
+}
+
+// diagnosticStore holds the diagnostics of a buffer by source, such as "lsp"
+// or "go vet", each source replacing its own; language servers publish them
+// while the user types, so the store is safe for concurrent use
+type diagnosticStore struct {
+	mu       sync.Mutex
+	bySource map[string][]diagnostic
+}
+
+// set replaces the diagnostics from source
+func (ds *diagnosticStore) set(source string, list []diagnostic) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.bySource == nil {
+		ds.bySource = make(map[string][]diagnostic)
+	}
+	ds.bySource[source] = list
+}
+
+// all returns the diagnostics of all sources, in the order of the text
+func (ds *diagnosticStore) all() (list []diagnostic) {
+	ds.mu.Lock()
+	for _, l := range ds.bySource {
+		list = append(list, l...)
+	}
+	ds.mu.Unlock()
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].start.isBefore(list[j].start)
+	})
+	return list
+}
+
+// signs returns the severity of the most severe diagnostic starting on each
+// line with diagnostics
+func (ds *diagnosticStore) signs() map[int]severity {
+	signs := make(map[int]severity)
+	for _, d := range ds.all() {
+		if sev, found := signs[d.start.line]; !found || d.severity < sev {
+			signs[d.start.line] = d.severity
+		}
+	}
+	return signs
+}
+
+// onLine returns the most severe diagnostic starting on line ln
+func (ds *diagnosticStore) onLine(ln int) (d diagnostic, found bool) {
+	for _, dd := range ds.all() {
+		if dd.start.line == ln && (!found || dd.severity < d.severity) {
+			d, found = dd, true
+		}
+	}
+	return d, found
+}
+
+// at returns the diagnostic for the position of m: the one covering m if any,
+// else the most severe on its line
+func (ds *diagnosticStore) at(m mark) (diagnostic, bool) {
+	for _, d := range ds.all() {
+		if !m.isBefore(d.start) && !d.end.isBefore(m) {
+			return d, true
+		}
+	}
+	return ds.onLine(m.line)
+}
+
+// nextDiagnostic moves to the next diagnostic with ]d, to the previous one
+// with [d
+func nextDiagnostic(ctx *cmdContext) {
+	for i := 0; i < ctx.num; i++ {
+		ctx.msg = toDiagnostic(ctx.view, ctx.cmdString == "]d")
+	}
+}
+
+// toDiagnostic moves the cursor of v to the next diagnostic, or to the
+// previous one if forward is false, going through all the open buffers
+func toDiagnostic(v *view, forward bool) string {
+	bufs := openBuffers()
+	cur := 0
+	for i, b := range bufs {
+		if b == v.buf {
+			cur = i
+		}
+	}
+	// the candidates in order: the ones after the cursor, the ones of the
+	// other buffers and finally the ones of the buffer from its start
+	var list []diagnostic
+	cs := *v.cs
+	if forward {
+		for _, d := range v.buf.diags.all() {
+			if cs.isBefore(d.start) {
+				list = append(list, d)
+			}
+		}
+		for i := 1; i < len(bufs); i++ {
+			list = append(list, bufs[(cur+i)%len(bufs)].diags.all()...)
+		}
+		list = append(list, v.buf.diags.all()...)
+	} else {
+		for _, d := range reversedDiagnostics(v.buf.diags.all()) {
+			if d.start.isBefore(cs) {
+				list = append(list, d)
+			}
+		}
+		for i := 1; i < len(bufs); i++ {
+			b := bufs[(cur-i+len(bufs))%len(bufs)]
+			list = append(list, reversedDiagnostics(b.diags.all())...)
+		}
+		list = append(list, reversedDiagnostics(v.buf.diags.all())...)
+	}
+	if len(list) == 0 {
+		return "No diagnostics"
+	}
+	v.moveTo(list[0].start)
+	v.cs.fixLineAndPos()
+	return list[0].String()
+}
+
+func reversedDiagnostics(list []diagnostic) []diagnostic {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// openBuffers returns the buffers of the backend, each once
+func openBuffers() (bufs []*buffer) {
+	seen := make(map[*buffer]bool)
+	for _, b := range be.bufs {
+		if !seen[b] {
+			seen[b] = true
+			bufs = append(bufs, b)
+		}
+	}
+	return bufs
+}
+
+// fileDiagnostic is a diagnostic of a file from the go command, which may
+// have no buffer
+type fileDiagnostic struct {
+	quickfixEntry
+	severity severity
+}
+
+// fileDiagnostics holds the diagnostics from the go command by file and by
+// source, for the buffers of the files opened later
+var fileDiagnostics = map[string]map[string][]fileDiagnostic{}
+
+// goDiagnostics parses the output of the go command run in dir, returning
+// the diagnostics by file
+func goDiagnostics(dir string, out []byte, sev severity) map[string][]fileDiagnostic {
+	diags := make(map[string][]fileDiagnostic)
+	for _, e := range parseFileLines(dir, out) {
+		diags[e.file] = append(diags[e.file], fileDiagnostic{e, sev})
+	}
+	return diags
+}
+
+// setFileDiagnostics replaces the diagnostics from source of all the files
+// with diags, and gives them to the buffers of the files already open
+func setFileDiagnostics(source string, diags map[string][]fileDiagnostic) {
+	for fp, bySource := range fileDiagnostics {
+		if delete(bySource, source); len(bySource) == 0 {
+			delete(fileDiagnostics, fp)
+		}
+	}
+	for fp, list := range diags {
+		if fileDiagnostics[fp] == nil {
+			fileDiagnostics[fp] = make(map[string][]fileDiagnostic)
+		}
+		fileDiagnostics[fp][source] = list
+	}
+	for _, b := range openBuffers() {
+		b.diags.set(source, b.toDiagnostics(diags[b.filename]))
+	}
+}
+
+// loadFileDiagnostics gives b, whose file was just read, the diagnostics of
+// its file from the go command
+func (b *buffer) loadFileDiagnostics() {
+	for source, list := range fileDiagnostics[b.filename] {
+		b.diags.set(source, b.toDiagnostics(list))
+	}
+}
+
+// toDiagnostics returns the diagnostics of b at the locations of list
+func (b *buffer) toDiagnostics(list []fileDiagnostic) []diagnostic {
+	var diags []diagnostic
+	for _, d := range list {
+		start := markAtByte(b, d.line-1, d.col-1)
+		diags = append(diags, diagnostic{start, start, d.severity, d.msg})
+	}
+	return diags
+}
+
+// markAtByte returns the mark in buffer b at line ln and byte offset col in
+// its UTF-8 encoding
+func markAtByte(b *buffer, ln, col int) mark {
+	m := mark{ln, 0, b}
+	m.fixLine()
+	for n, s := 0, b.text.line(m.line); m.pos < len(s)-1 && n < col; m.pos++ {
+		n += len(string(s[m.pos]))
+	}
+	return m
+}
+
+// runGo runs the go command with args in the module of the buffer of v and
+// replaces the diagnostics from it in all files
+func runGo(v *view, sev severity, args ...string) string {
+	source := "go " + args[0]
+	dir := moduleRoot(filepath.Dir(v.buf.filename))
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return err.Error()
+	}
+	diags := goDiagnostics(dir, out, sev)
+	n := 0
+	for _, list := range diags {
+		n += len(list)
+	}
+	setFileDiagnostics(source, diags)
+	switch {
+	case n > 0:
+		return fmt.Sprintf("%v: %v problems", source, n)
+	case err != nil:
+		// a failure we could not parse
+		return fmt.Sprintf("%v: %s", source, bytes.SplitN(out, []byte("\n"), 2)[0])
+	}
+	return source + ": no problems"
+}
+
+func goBuild(v *view, args []string) (msg string) {
+	return runGo(v, sevError, "build", "./...")
+}
+
+func goVet(v *view, args []string) (msg string) {
+	return runGo(v, sevWarning, "vet", "./...")
+}
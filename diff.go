@@ -0,0 +1,127 @@
+package main
+
+// maxDiffEdits limits the work of diffLines, beyond it the lines that differ
+// are replaced as a whole
+const maxDiffEdits = 2000
+
+// hunk is a difference between two texts: the lines of the old text from
+// from up to to excluded are replaced by the lines of the new text from
+// newFrom up to newTo excluded
+type hunk struct {
+	from, to       int
+	newFrom, newTo int
+}
+
+// diffLines returns the hunks changing lines a into lines b, with the
+// minimum number of lines inserted and deleted (Myers' algorithm)
+func diffLines(a, b []string) []hunk {
+	// the common lines at the start and at the end need no search
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre &&
+		a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	pairs := commonLines(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	var hunks []hunk
+	i, j := 0, 0
+	for _, p := range append(pairs, [2]int{len(a) - pre - suf, len(b) - pre - suf}) {
+		if p[0] > i || p[1] > j {
+			hunks = append(hunks, hunk{pre + i, pre + p[0], pre + j, pre + p[1]})
+		}
+		i, j = p[0]+1, p[1]+1
+	}
+	return hunks
+}
+
+// commonLines returns the pairs of indexes of the lines kept from a to b in
+// a shortest edit script, in order
+func commonLines(a, b []string) (pairs [][2]int) {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	// v[off+k] is the furthest x reached on diagonal k = x-y
+	off := max + 1
+	v := make([]int, 2*max+2)
+	// trace[d] holds v before step d for the diagonals -d to d, the only
+	// ones it reached, so that it grows with d² and not with d·max
+	var trace [][]int
+	d := 0
+found:
+	for ; d <= max; d++ {
+		if d > maxDiffEdits {
+			return nil
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break found
+			}
+		}
+	}
+	// we walk back the path from the end, collecting the diagonal moves
+	x, y := n, m
+	for ; d > 0; d-- {
+		// v[d+k] is the furthest x on diagonal k before step d
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			pairs = append(pairs, [2]int{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		pairs = append(pairs, [2]int{x, y})
+	}
+	for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+		pairs[i], pairs[j] = pairs[j], pairs[i]
+	}
+	return pairs
+}
+
+// mapLine returns the line of the new text corresponding to line ln of the
+// old text, given the hunks between them
+func mapLine(hunks []hunk, ln int) int {
+	shift := 0
+	for _, h := range hunks {
+		switch {
+		case ln < h.from:
+			return ln + shift
+		case ln < h.to:
+			// a changed line maps to the line at the same offset in the hunk
+			if off := ln - h.from; off < h.newTo-h.newFrom {
+				return h.newFrom + off
+			}
+			if h.newTo > h.newFrom {
+				return h.newTo - 1
+			}
+			return h.newFrom
+		}
+		shift = h.newTo - h.to
+	}
+	return ln + shift
+}
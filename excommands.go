@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+func init() {
+	for name, f := range map[string]rangeCommandF{
+		"w":      write,
+		"write":  write,
+		"wq":     writeQuit,
+		"x":      writeQuit,
+		"xit":    writeQuit,
+		"r":      read,
+		"read":   read,
+		"d":      deleteRange,
+		"delete": deleteRange,
+		"m":      moveRange,
+		"move":   moveRange,
+		"t":      copyRange,
+		"co":     copyRange,
+		"copy":   copyRange,
+		">":      shiftRange(true),
+		"<":      shiftRange(false),
+		"norm":   normal,
+		"normal": normal,
+	} {
+		rangeCommandFuncs[name] = f
+	}
+	zeroAddressCommands["r"] = true
+	zeroAddressCommands["read"] = true
+	commandModeFuncs["e"] = edit
+	commandModeFuncs["edit"] = edit
+	commandModeFuncs["saveas"] = saveBufferAs
+	commandModeFuncs["wa"] = writeAll
+	commandModeFuncs["wall"] = writeAll
+	commandModeFuncs["wqa"] = writeAllQuit
+	commandModeFuncs["wqall"] = writeAllQuit
+	commandModeFuncs["xa"] = writeAllQuit
+}
+
+// saveBuffer saves the buffer of v after running the hooks of its file type;
+// it returns the message to show and false if the buffer could not be saved
+func saveBuffer(v *view) (msg string, ok bool) {
+	hookErr := runBeforeSave(v)
+	if err := v.buf.save(); err != nil {
+		return err.Error(), false
+	}
+	if hookErr != nil {
+		return "file saved, " + hookErr.Error(), true
+	}
+	return "file saved", true
+}
+
+// write saves the buffer of v, or copies it to the file arg if given; with a
+// range only the lines of the range are written, to the file arg
+func write(v *view, rg lineRange, arg string) (msg string) {
+	msg, _ = writeRange(v, rg, arg)
+	return msg
+}
+
+// writeQuit writes like write and quits if it succeeded
+func writeQuit(v *view, rg lineRange, arg string) (msg string) {
+	msg, ok := writeRange(v, rg, arg)
+	if !ok {
+		return msg
+	}
+	return quit(v, nil)
+}
+
+func writeRange(v *view, rg lineRange, arg string) (msg string, ok bool) {
+	b := v.buf
+	if rg.set && (rg.from > 0 || rg.to < b.text.len()-1) {
+		if arg == "" {
+			return "Usage: [range]w file", false
+		}
+		var data []byte
+		for _, ln := range b.text.lines(rg.from, rg.to+1) {
+			data = append(data, ln.toBytes()...)
+		}
+		if err := writeFile(arg, data); err != nil {
+			return err.Error(), false
+		}
+		return fmt.Sprintf("%v lines written to %v", rg.to-rg.from+1, arg), true
+	}
+	if arg != "" && filename(arg) != b.filename {
+		if err := b.saveAs(filename(arg)); err != nil {
+			return err.Error(), false
+		}
+		return "file saved to " + arg, true
+	}
+	return saveBuffer(v)
+}
+
+// writeAll saves the modified buffers of the buffer list
+func writeAll(v *view, args []string) (msg string) {
+	msg, _ = saveModified(v)
+	return msg
+}
+
+// writeAllQuit saves the modified buffers of the buffer list and quits if
+// they were all saved
+func writeAllQuit(v *view, args []string) (msg string) {
+	msg, ok := saveModified(v)
+	if !ok {
+		return msg
+	}
+	return quit(v, nil)
+}
+
+// saveModified saves the modified buffers of the buffer list, the buffer of
+// v with its cursor and the others with their saved one. It stops at the
+// first buffer that cannot be saved
+func saveModified(v *view) (msg string, ok bool) {
+	n := 0
+	for _, b := range listedBuffers() {
+		if !b.modified {
+			continue
+		}
+		bv := v
+		if b != v.buf {
+			cs := b.savedCursor
+			cs.buf = b
+			bv = &view{b, &cs, 0}
+		}
+		msg, ok := saveBuffer(bv)
+		if bv != v {
+			b.savedCursor = *bv.cs
+		}
+		if !ok {
+			return b.name + ": " + msg, false
+		}
+		n++
+	}
+	return fmt.Sprintf("%v files saved", n), true
+}
+
+// saveBufferAs gives the buffer of v the file name of the first arg and saves
+// it
+func saveBufferAs(v *view, args []string) (msg string) {
+	name := strings.TrimSpace(strings.Join(args, " "))
+	if name == "" {
+		return "Usage: saveas file"
+	}
+	b, fp := v.buf, filename(name)
+	if other := be.findBuffer(fp); other != nil && other != b {
+		return "File already open: " + fp
+	}
+	b.filename, b.name = fp, path.Base(fp)
+	b.setFiletype(detectFiletype(fp, b.text.line(0).toBytes()))
+	msg, _ = saveBuffer(v)
+	return msg
+}
+
+// edit shows the file of the first arg in view v, opening it if needed, or
+// reloads the file of the buffer of v without args unless it is modified
+func edit(v *view, args []string) (msg string) {
+	name := strings.TrimSpace(strings.Join(args, " "))
+	if name == "" {
+		if v.buf.modified {
+			return unsavedMessage(v.buf)
+		}
+		return reload(v, nil)
+	}
+	b, err := be.bufferFor(name)
+	if err != nil {
+		return err.Error()
+	}
+	v.showBuffer(b)
+	return bufferInfo(v)
+}
+
+// read inserts the content of the file arg after the last line of the range,
+// before the first line for the range 0
+func read(v *view, rg lineRange, arg string) (msg string) {
+	if arg == "" {
+		return "Usage: [range]r file"
+	}
+	content, err := ioutil.ReadFile(arg)
+	switch {
+	case os.IsNotExist(err):
+		return "No such file: " + arg
+	case err != nil:
+		return err.Error()
+	case len(content) == 0:
+		return ""
+	}
+	t := text(ropeFromBytes(content).all())
+	v.buf.insertLinesAfter(rg.to, t)
+	*v.cs = mark{rg.to + 1, 0, v.buf}
+	_, v.cs.pos = lineIndent(v.buf, v.cs.line)
+	return ""
+}
+
+// deleteRange deletes the lines of the range in the register named by arg,
+// the unnamed one by default
+func deleteRange(v *view, rg lineRange, arg string) (msg string) {
+	var reg rune
+	if arg != "" {
+		if len(arg) != 1 || !isRegisterName(rune(arg[0])) {
+			return "Invalid register: " + arg
+		}
+		reg = rune(arg[0])
+	}
+	b := v.buf
+	r.texts.delete(reg, b.text.lines(rg.from, rg.to+1), true)
+	defer b.recordLinesChange(rg.from, rg.to)()
+	b.deleteLines(mark{rg.from, 0, b}, mark{rg.to, 0, b})
+	*v.cs = mark{rg.from, 0, b}
+	return ""
+}
+
+// moveRange moves the lines of the range below the line at address arg, 0
+// to move them above the first line
+func moveRange(v *view, rg lineRange, arg string) (msg string) {
+	b := v.buf
+	to, err := destination(*v.cs, arg)
+	switch {
+	case err != nil:
+		return err.Error()
+	case to >= rg.from && to < rg.to:
+		return "Cannot move lines into themselves"
+	case to == rg.to || to == rg.from-1:
+		return ""
+	}
+	t := copyText(b.text.lines(rg.from, rg.to+1))
+	l1, l2 := rg.from, to
+	if to < rg.from {
+		l1, l2 = to+1, rg.to
+	}
+	defer b.recordLinesChange(l1, l2)()
+	b.text.delete(rg.from, rg.to+1)
+	at := to + 1
+	if to > rg.to {
+		at -= len(t)
+	}
+	b.text.insert(at, t...)
+	*v.cs = mark{at + len(t) - 1, 0, b}
+	_, v.cs.pos = lineIndent(b, v.cs.line)
+	return ""
+}
+
+// copyRange copies the lines of the range below the line at address arg, 0
+// to copy them above the first line
+func copyRange(v *view, rg lineRange, arg string) (msg string) {
+	b := v.buf
+	to, err := destination(*v.cs, arg)
+	if err != nil {
+		return err.Error()
+	}
+	t := copyText(b.text.lines(rg.from, rg.to+1))
+	b.insertLinesAfter(to, t)
+	*v.cs = mark{to + len(t), 0, b}
+	_, v.cs.pos = lineIndent(b, v.cs.line)
+	return ""
+}
+
+// destination returns the line at address arg, which can be 0 for before
+// the first line
+func destination(cs mark, arg string) (int, error) {
+	ln, rest, ok, err := parseAddress(cs, arg)
+	switch {
+	case err != nil:
+		return 0, err
+	case !ok || strings.TrimSpace(rest) != "":
+		return 0, fmt.Errorf("Invalid address: %v", arg)
+	case ln < -1 || ln > cs.lastLine():
+		return 0, errInvalidRange
+	}
+	return ln, nil
+}
+
+// insertLinesAfter inserts the lines t, each ending with a newline, after
+// line ln, or before the first line if ln is -1, as one change
+func (b *buffer) insertLinesAfter(ln int, t text) {
+	start := mark{0, 0, b}
+	if ln >= 0 {
+		// as put does, the newline of line ln moves to the end of the text
+		start = mark{ln, 0, b}
+		start.pos = start.lineEndPos()
+		t = append(text{line{'\n'}}, t...)
+		last := t[len(t)-1]
+		t[len(t)-1] = last[:len(last)-1]
+	}
+	start.insertText(t)
+	b.changeList.addReplace(start, nil, t)
+}
+
+// shiftRange returns the command shifting the lines of the range right (or
+// left) by one level of indentation, plus one for each '>' (or '<') in arg
+func shiftRange(right bool) rangeCommandF {
+	shift := ">"
+	if !right {
+		shift = "<"
+	}
+	return func(v *view, rg lineRange, arg string) (msg string) {
+		if strings.Trim(arg, shift) != "" {
+			return "Usage: [range]" + shift
+		}
+		n := len(arg) + 1
+		b := v.buf
+		defer b.recordLinesChange(rg.from, rg.to)()
+		for ln := rg.from; ln <= rg.to; ln++ {
+			for i := 0; i < n; i++ {
+				b.shiftLine(ln, right)
+			}
+		}
+		*v.cs = mark{rg.to, 0, b}
+		_, v.cs.pos = lineIndent(b, rg.to)
+		return ""
+	}
+}
+
+// normal executes the keys of arg in normalMode on each line of the range,
+// with the cursor at the start of the line
+func normal(v *view, rg lineRange, arg string) (msg string) {
+	if arg == "" {
+		return "Usage: [range]normal keys"
+	}
+	var keys []Keypress
+	for _, c := range arg {
+		keys = append(keys, Keypress{Char: c})
+	}
+	b := v.buf
+	b.changeList.init()
+	start := b.changeList.current
+	last := rg.to
+	for ln := rg.from; ln <= last && ln <= v.cs.lastLine(); {
+		n := v.buf.text.len()
+		*v.cs = mark{ln, 0, v.buf}
+		runKeys(v, keys)
+		// skip the lines added by the keys, stay on the next line if lines
+		// were deleted
+		added := v.buf.text.len() - n
+		last += added
+		if added >= 0 {
+			ln += added + 1
+		}
+	}
+	if v.buf == b {
+		// the changes of all the lines are undone at once
+		b.groupChanges(start, v.cs)
+	}
+	return ""
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// lineRange is a range of lines given to a command in commandMode, with lines
+// counted from 0 and both ends included
+type lineRange struct {
+	from, to int
+	set      bool // false if the command was given no range
+}
+
+// rangeCommandF is a command accepting a range of lines; arg is what follows
+// the command name
+type rangeCommandF func(v *view, rg lineRange, arg string) (msg string)
+
+// rangeCommandFuncs are the commandMode commands accepting a range of lines;
+// without a range they act on the cursor line
+var rangeCommandFuncs = map[string]rangeCommandF{
+	"s":          substitute,
+	"substitute": substitute,
+}
+
+// zeroAddressCommands are the commands accepting the range 0, before the
+// first line
+var zeroAddressCommands = map[string]bool{}
+
+var errInvalidRange = errors.New("Invalid range")
+
+// parseRange parses the range at the start of cmd, such as "%", ".,$",
+// "10,20", "'a,'b" or "/func/;/^}/", for the buffer with cursor cs and
+// returns it together with the rest of cmd. With ';' the second address is
+// relative to the first one instead of the cursor. A lone address 0, before
+// the first line, gives a range from -1 to -1
+func parseRange(cs mark, cmd string) (rg lineRange, rest string, err error) {
+	if strings.HasPrefix(cmd, "%") {
+		return lineRange{0, cs.lastLine(), true}, cmd[1:], nil
+	}
+	from, rest, ok, err := parseAddress(cs, cmd)
+	if err != nil || !ok {
+		return lineRange{cs.line, cs.line, false}, rest, err
+	}
+	to := from
+	if strings.HasPrefix(rest, ",") || strings.HasPrefix(rest, ";") {
+		if rest[0] == ';' {
+			cs.line = from
+		}
+		if to, rest, ok, err = parseAddress(cs, rest[1:]); err != nil {
+			return rg, rest, err
+		}
+		if !ok {
+			to = cs.line
+		}
+	}
+	if from > to {
+		from, to = to, from
+	}
+	if from < -1 || from == -1 && to != -1 || to > cs.lastLine() {
+		return rg, rest, errInvalidRange
+	}
+	return lineRange{from, to, true}, rest, nil
+}
+
+// parseAddress parses a line address at the start of s: a line number, '.'
+// for the cursor line, '$' for the last line, 'x for the line of mark x or
+// /pattern/ (?pattern?) for the next (previous) line matching pattern,
+// optionally followed by +n or -n offsets. It returns ok false if s does not
+// start with an address
+func parseAddress(cs mark, s string) (ln int, rest string, ok bool, err error) {
+	switch {
+	case s == "":
+		return 0, s, false, nil
+	case s[0] == '.':
+		ln, s = cs.line, s[1:]
+	case s[0] == '$':
+		ln, s = cs.lastLine(), s[1:]
+	case s[0] == '\'':
+		if len(s) < 2 {
+			return 0, s, false, errInvalidRange
+		}
+		m, found := cs.buf.marks[rune(s[1])]
+		if !found {
+			return 0, s, false, errors.New("Mark not set: " + s[:2])
+		}
+		ln, s = m.line, s[2:]
+	case s[0] == '/' || s[0] == '?':
+		if ln, s, err = searchAddress(cs, s); err != nil {
+			return 0, s, false, err
+		}
+	case unicode.IsDigit(rune(s[0])):
+		n, r := leadingNumber(s)
+		ln, s = n-1, r
+	case s[0] == '+' || s[0] == '-':
+		ln = cs.line
+	default:
+		return 0, s, false, nil
+	}
+	for len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		n, r := leadingNumber(s[1:])
+		if r == s[1:] {
+			n = 1
+		}
+		if s[0] == '-' {
+			n = -n
+		}
+		ln, s = ln+n, r
+	}
+	return ln, s, true, nil
+}
+
+// searchAddress returns the line of the match of the pattern at the start of
+// s, delimited by '/' to search forward from the line after the cursor line
+// or by '?' to search backward from the line before, wrapping around the
+// text, and the rest of s. An empty pattern is the last search pattern, the
+// pattern given becomes the last search pattern
+func searchAddress(cs mark, s string) (ln int, rest string, err error) {
+	delim := s[0]
+	var pattern []byte
+	i := 1
+	for ; i < len(s) && s[i] != delim; i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == delim {
+			i++
+		}
+		pattern = append(pattern, s[i])
+	}
+	if i < len(s) {
+		i++
+	}
+	rest = s[i:]
+	re := r.searches.re
+	if len(pattern) > 0 {
+		if re, err = regexp.Compile(string(pattern)); err != nil {
+			return 0, rest, errors.New("Invalid pattern: " + err.Error())
+		}
+	}
+	if re == nil {
+		return 0, rest, errors.New("No previous search pattern")
+	}
+	r.searches.re = re
+	dir, m := right, mark{cs.line, 0, cs.buf}
+	m.pos = m.lineEndPos()
+	if delim == '?' {
+		dir, m.pos = left, 0
+	}
+	m, found := find(m, re, dir)
+	if !found {
+		return 0, rest, errors.New("Pattern not found: " + re.String())
+	}
+	return m.line, rest, nil
+}
+
+// leadingNumber returns the number at the start of s, if any, and the rest
+// of s
+func leadingNumber(s string) (int, string) {
+	i := 0
+	for i < len(s) && unicode.IsDigit(rune(s[i])) {
+		i++
+	}
+	n, _ := strconv.Atoi(s[:i])
+	return n, s[i:]
+}
+
+// commandName returns the name of the command at the start of cmd, made of
+// letters only so that it can be directly followed by its argument, as in
+// s/a/b/, or one of the shift commands '>' and '<'
+func commandName(cmd string) string {
+	if strings.HasPrefix(cmd, ">") || strings.HasPrefix(cmd, "<") {
+		return cmd[:1]
+	}
+	i := 0
+	for i < len(cmd) && unicode.IsLetter(rune(cmd[i])) {
+		i++
+	}
+	return cmd[:i]
+}
+
+// setMark sets the mark named by the first arg at the cursor
+func setMark(v *view, args []string) (msg string) {
+	if len(args) != 1 || len(args[0]) != 1 ||
+		!unicode.IsLetter(rune(args[0][0])) {
+		return "Usage: mark {a-z}"
+	}
+	v.buf.setMark(rune(args[0][0]), *v.cs)
+	return ""
+}
+
+// setMark sets the mark named name at m
+func (b *buffer) setMark(name rune, m mark) {
+	if b.marks == nil {
+		b.marks = make(map[rune]mark)
+	}
+	b.marks[name] = m
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// filetype identifies the kind of text of a buffer, that decides its
+// settings
+type filetype int
+
+const (
+	anyFiletype filetype = iota // plain text, the settings by default
+	_go
+)
+
+// filetypeInfo holds the settings of a file type
+type filetypeInfo struct {
+	name         string
+	expandTab    bool   // true to indent with spaces instead of tabs
+	tabStop      int    // the width of a tab and of a level of indentation
+	comment      string // the line comment marker, "" if there is none
+	indent       indentFunc
+	indentRegion indentRegionFunc // to indent many lines at once, if cheaper
+	indentKeys   []rune           // the keys re-indenting the line in insertMode
+	beforeSave   []func(v *view) error
+	// run after a change to the text, once out of insertMode; the ones of
+	// anyFiletype run for all the buffers
+	afterChange []func(v *view) error
+}
+
+// filetypeInfos are the settings of the registered file types, by filetype
+var filetypeInfos = []*filetypeInfo{
+	anyFiletype: {name: "text", tabStop: 4, comment: "#"},
+	_go:         {name: "go", tabStop: 4, comment: "//"},
+}
+
+// the file types by extension, file name and interpreter in shebang lines
+var (
+	filetypeExts     = map[string]filetype{".go": _go}
+	filetypeNames    = map[string]filetype{}
+	filetypeShebangs = map[string]filetype{}
+)
+
+func init() {
+	registerFiletype(&filetypeInfo{name: "gomod", tabStop: 4, comment: "//"},
+		nil, []string{"go.mod", "go.work"}, nil)
+	registerFiletype(&filetypeInfo{name: "make", tabStop: 8, comment: "#"},
+		[]string{".mk"}, []string{"Makefile", "makefile", "GNUmakefile"}, nil)
+	registerFiletype(&filetypeInfo{name: "yaml", expandTab: true, tabStop: 2,
+		comment: "#"}, []string{".yaml", ".yml"}, nil, nil)
+	registerFiletype(&filetypeInfo{name: "markdown", expandTab: true, tabStop: 2},
+		[]string{".md", ".markdown"}, nil, nil)
+	registerFiletype(&filetypeInfo{name: "shell", expandTab: true, tabStop: 2,
+		comment: "#"}, []string{".sh", ".bash"}, nil, []string{"sh", "bash", "zsh"})
+	registerFiletype(&filetypeInfo{name: "python", expandTab: true, tabStop: 4,
+		comment: "#"}, []string{".py"}, nil, []string{"python", "python3"})
+}
+
+// registerFiletype adds the file type with settings info, detected by the
+// extensions, the file names or the interpreters of the shebang line given,
+// and returns it
+func registerFiletype(info *filetypeInfo, exts, names, shebangs []string) filetype {
+	ft := filetype(len(filetypeInfos))
+	filetypeInfos = append(filetypeInfos, info)
+	for _, e := range exts {
+		filetypeExts[e] = ft
+	}
+	for _, n := range names {
+		filetypeNames[n] = ft
+	}
+	for _, s := range shebangs {
+		filetypeShebangs[s] = ft
+	}
+	return ft
+}
+
+// info returns the settings of file type ft
+func (ft filetype) info() *filetypeInfo {
+	if int(ft) < len(filetypeInfos) {
+		return filetypeInfos[ft]
+	}
+	return filetypeInfos[anyFiletype]
+}
+
+// detectFiletype returns the file type of the file at fp whose text starts
+// with firstLine: by its name, else by its extension, else by the
+// interpreter of its shebang line
+func detectFiletype(fp string, firstLine []byte) filetype {
+	if ft, found := filetypeNames[filepath.Base(fp)]; found {
+		return ft
+	}
+	if ft, found := filetypeExts[filepath.Ext(fp)]; found {
+		return ft
+	}
+	if !bytes.HasPrefix(firstLine, []byte("#!")) {
+		return anyFiletype
+	}
+	// #!/bin/sh or #!/usr/bin/env python3 -u
+	args := strings.Fields(string(firstLine[2:]))
+	if len(args) > 1 && filepath.Base(args[0]) == "env" {
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		if ft, found := filetypeShebangs[filepath.Base(args[0])]; found {
+			return ft
+		}
+	}
+	return anyFiletype
+}
+
+// setFiletype gives buffer b the file type ft and its settings
+func (b *buffer) setFiletype(ft filetype) {
+	info := ft.info()
+	b.filetype = ft
+	b.tabStop, b.expandTab = info.tabStop, info.expandTab
+}
+
+// runBeforeSave runs the hooks of the file type of the buffer of v before
+// saving it, returning the last error
+func runBeforeSave(v *view) (err error) {
+	for _, fn := range v.buf.filetype.info().beforeSave {
+		if e := fn(v); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// runAfterChange runs the hooks of any file type and then those of the file
+// type of the buffer of v after a change to its text, returning the last
+// error
+func runAfterChange(v *view) (err error) {
+	hooks := anyFiletype.info().afterChange
+	if ft := v.buf.filetype; ft != anyFiletype {
+		hooks = append(hooks[:len(hooks):len(hooks)], ft.info().afterChange...)
+	}
+	for _, fn := range hooks {
+		if e := fn(v); e != nil {
+			err = e
+		}
+	}
+	return err
+}
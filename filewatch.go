@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileCheckInterval is the minimum time between two checks of the files of
+// the buffers, done while waiting for keys; 0 turns these checks off
+var fileCheckInterval = time.Second
+
+// lastFileCheck is when the files were last checked
+var lastFileCheck time.Time
+
+func init() {
+	commandModeFuncs["checktime"] = checkTime
+	commandModeFuncs["e!"] = reload
+	commandModeFuncs["edit!"] = reload
+	commandModeFuncs["merge"] = mergeFile
+}
+
+// pollFiles checks the files of the buffers if it was not done in the last
+// fileCheckInterval; it is posted as a command by the goroutine parsing the
+// keys while it waits for keys
+func pollFiles(ctx *cmdContext) {
+	ctx.silent = true
+	if fileCheckInterval == 0 || time.Since(lastFileCheck) < fileCheckInterval {
+		return
+	}
+	if msg := checkFiles(ctx.view); msg != "" {
+		ctx.msg, ctx.silent = msg, false
+	}
+}
+
+// checkFiles checks the files of the buffers of the buffer list for changes
+// made outside of the editor: the buffers not modified are reloaded, the
+// others are left alone with a warning. v is the current view, whose cursor
+// follows the lines of its buffer
+func checkFiles(v *view) (msg string) {
+	lastFileCheck = time.Now()
+	var msgs []string
+	for _, b := range listedBuffers() {
+		cs := &b.savedCursor
+		if v != nil && v.buf == b {
+			cs = v.cs
+		}
+		cs.buf = b
+		if msg := b.checkFile(cs); msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// checkTime checks at once the files of the buffers for external changes
+func checkTime(v *view, args []string) (msg string) {
+	return checkFiles(v)
+}
+
+// checkFile reloads b, moving the cursor cs, if its file changed since it was
+// read or saved and b is not modified; if b is modified it returns a warning
+// once for each change
+func (b *buffer) checkFile(cs *mark) (msg string) {
+	fi, err := os.Stat(b.filename)
+	if err != nil || fi.ModTime().Equal(b.fileSync) || fi.ModTime().Equal(b.diskChange) {
+		return ""
+	}
+	if b.modified || b.mod == insertMode {
+		// the saved text is gone, undoing the changes cannot give it back
+		b.diskChange, b.savedChange = fi.ModTime(), nil
+		b.updateModified()
+		return fmt.Sprintf("%v changed on disk, :e! to reload it or :merge to merge it",
+			b.name)
+	}
+	if err := b.reopen(cs); err != nil {
+		return err.Error()
+	}
+	return b.name + " reloaded"
+}
+
+// reload reloads the buffer of v from its file, dropping its changes
+func reload(v *view, args []string) (msg string) {
+	if err := v.buf.reopen(v.cs); err != nil {
+		return err.Error()
+	}
+	v.buf.diskChange = time.Time{}
+	return v.buf.name + " reloaded"
+}
+
+// mergeFile merges into the buffer of v the changes made to its file since it
+// was read or saved, keeping the changes of the buffer; where they conflict
+// both versions are kept between conflict markers
+func mergeFile(v *view, args []string) (msg string) {
+	b := v.buf
+	f, err := os.Open(b.filename)
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err.Error()
+	}
+	merged, conflicts := merge3(bytesToLines(b.fileContent),
+		linesToStrings(b.text.all()), bytesToLines(content))
+	lines := []line{newLine()}
+	if len(merged) > 0 {
+		lines = make([]line, len(merged))
+		for i, s := range merged {
+			lines[i] = stringToLine(s)
+		}
+	}
+	b.replaceText(lines, v.cs)
+	b.fileContent, b.fileSync, b.diskChange = content, fileTime(f), time.Time{}
+	b.savedChange = nil
+	if conflicts > 0 {
+		return fmt.Sprintf("%v merged, %v conflicts", b.name, conflicts)
+	}
+	return b.name + " merged"
+}
+
+// bytesToLines returns the lines of text b, each ending with a newline
+func bytesToLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	return linesToStrings(ropeFromBytes(b).all())
+}
+
+func linesToStrings(lines []line) []string {
+	s := make([]string, len(lines))
+	for i, ln := range lines {
+		s[i] = string(ln)
+	}
+	return s
+}
+
+// merge3 merges the changes made to lines base in a and in b. The changes of
+// a and b to the same lines of base, or starting at the same line, conflict
+// unless they are the same: both versions are kept between conflict markers.
+// It returns the merged lines and the number of conflicts
+func merge3(base, a, b []string) (merged []string, conflicts int) {
+	ha, hb := diffLines(base, a), diffLines(base, b)
+	next := 0 // the first line of base not merged yet
+	for len(ha) > 0 || len(hb) > 0 {
+		// the changes of both sides overlapping the first one
+		var ga, gb []hunk
+		start := len(base)
+		if len(ha) > 0 {
+			start = ha[0].from
+		}
+		if len(hb) > 0 && hb[0].from < start {
+			start = hb[0].from
+		}
+		end := start
+	group:
+		for {
+			switch {
+			case len(ha) > 0 && (ha[0].from < end || ha[0].from == start):
+				if ga = append(ga, ha[0]); ha[0].to > end {
+					end = ha[0].to
+				}
+				ha = ha[1:]
+			case len(hb) > 0 && (hb[0].from < end || hb[0].from == start):
+				if gb = append(gb, hb[0]); hb[0].to > end {
+					end = hb[0].to
+				}
+				hb = hb[1:]
+			default:
+				break group
+			}
+		}
+		merged = append(merged, base[next:start]...)
+		sa, sb := mergeSide(base, a, ga, start, end), mergeSide(base, b, gb, start, end)
+		switch {
+		case len(ga) == 0:
+			merged = append(merged, sb...)
+		case len(gb) == 0 || strings.Join(sa, "") == strings.Join(sb, ""):
+			merged = append(merged, sa...)
+		default:
+			conflicts++
+			merged = append(merged, "<<<<<<< buffer\n")
+			merged = append(merged, sa...)
+			merged = append(merged, "=======\n")
+			merged = append(merged, sb...)
+			merged = append(merged, ">>>>>>> file\n")
+		}
+		next = end
+	}
+	return append(merged, base[next:]...), conflicts
+}
+
+// mergeSide returns the lines of side replacing the lines of base from start
+// to end excluded, given the hunks between base and side in that range
+func mergeSide(base, side []string, hunks []hunk, start, end int) []string {
+	if len(hunks) == 0 {
+		return base[start:end]
+	}
+	first, last := hunks[0], hunks[len(hunks)-1]
+	return side[first.newFrom-(first.from-start) : last.newTo+(end-last.to)]
+}
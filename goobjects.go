@@ -0,0 +1,318 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+)
+
+// the Go text objects and the motions between declarations, computed from
+// the syntax tree of the buffer
+func init() {
+	goObjects := map[string]regionFunc{
+		"if": innerGoFunc,
+		"af": aGoFunc,
+		"it": innerGoType,
+		"at": aGoType,
+		"ib": innerGoBlock,
+		"ab": aGoBlock,
+	}
+	for k, f := range goObjects {
+		textObjects[k] = f
+		regionFuncs[k] = f
+	}
+	linewiseObjects["af"] = aGoFuncLines
+	linewiseObjects["at"] = aGoTypeLines
+	motions["]]"], motions["[["] = toNextDecl, toPrevDecl
+	for _, k := range []string{"]]", "[["} {
+		regionFuncs[k] = motions[k]
+		exclusiveMotions[k] = true
+		cmdStringNormalMode[k] = command{moveCursorTo, nil}
+	}
+}
+
+// goFile is the syntax tree of the text of a Go buffer
+type goFile struct {
+	b    *buffer
+	fset *token.FileSet
+	tf   *token.File
+	file *ast.File
+}
+
+// parseGoBuffer parses the text of b, returning nil if b is not a Go buffer
+// or its text is too broken to give a syntax tree
+func parseGoBuffer(b *buffer) *goFile {
+	if b.filetype != _go {
+		return nil
+	}
+	src, _ := ioutil.ReadAll(&bufReader{buf: b})
+	fset := token.NewFileSet()
+	base := fset.Base()
+	// the partial tree of a text with errors is still useful while editing
+	f, _ := parser.ParseFile(fset, b.filename, src, parser.ParseComments)
+	tf := fset.File(token.Pos(base))
+	if f == nil || tf == nil {
+		return nil
+	}
+	// the parser may stop before the end of the text
+	tf.SetLinesForContent(src)
+	return &goFile{b, fset, tf, f}
+}
+
+// mark returns the mark at position p
+func (g *goFile) mark(p token.Pos) mark {
+	pos := g.tf.Position(p)
+	return markAtByte(g.b, pos.Line-1, pos.Column-1)
+}
+
+// line returns the line of position p, from 0
+func (g *goFile) line(p token.Pos) int {
+	return g.tf.Line(p) - 1
+}
+
+// pos returns the position of mark m
+func (g *goFile) pos(m mark) token.Pos {
+	return g.tf.LineStart(m.line+1) + token.Pos(len(string(g.b.text.line(m.line)[:m.pos])))
+}
+
+// nodeStart returns the start of node n, including its doc comment
+func nodeStart(n ast.Node) token.Pos {
+	var doc *ast.CommentGroup
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		doc = n.Doc
+	case *ast.GenDecl:
+		doc = n.Doc
+	case *ast.TypeSpec:
+		doc = n.Doc
+	}
+	if doc != nil {
+		return doc.Pos()
+	}
+	return n.Pos()
+}
+
+// path returns the nodes around mark m, from the file to the innermost
+func (g *goFile) path(m mark) (path []ast.Node) {
+	p := g.pos(m)
+	ast.Inspect(g.file, func(n ast.Node) bool {
+		if n == nil || p < nodeStart(n) || p >= n.End() {
+			return false
+		}
+		path = append(path, n)
+		return true
+	})
+	return path
+}
+
+// funcAt returns the innermost function declaration or literal around m
+func (g *goFile) funcAt(m mark) (n ast.Node, body *ast.BlockStmt) {
+	path := g.path(m)
+	for i := len(path) - 1; i >= 0; i-- {
+		switch f := path[i].(type) {
+		case *ast.FuncLit:
+			return f, f.Body
+		case *ast.FuncDecl:
+			if f.Body != nil {
+				return f, f.Body
+			}
+		}
+	}
+	return nil, nil
+}
+
+// typeAt returns the innermost type declaration around m and the node of
+// its lines: the whole declaration unless it is part of a group
+func (g *goFile) typeAt(m mark) (spec *ast.TypeSpec, lines ast.Node) {
+	path := g.path(m)
+	for i := len(path) - 1; i >= 0; i-- {
+		switch n := path[i].(type) {
+		case *ast.TypeSpec:
+			if d, ok := path[i-1].(*ast.GenDecl); ok && !d.Lparen.IsValid() {
+				return n, d
+			}
+			return n, n
+		case *ast.GenDecl:
+			if n.Tok != token.TYPE {
+				continue
+			}
+			// on the indentation of a type in a group, or on the type
+			// keyword and the doc comment of a single declaration
+			for _, s := range n.Specs {
+				if l1, l2 := g.nodeLines(s); m.line >= l1 && m.line <= l2 && n.Lparen.IsValid() {
+					return s.(*ast.TypeSpec), s
+				}
+			}
+			if len(n.Specs) == 1 {
+				return n.Specs[0].(*ast.TypeSpec), n
+			}
+		}
+	}
+	return nil, nil
+}
+
+// blockAt returns the braces of the innermost block, composite literal,
+// struct or interface around m
+func (g *goFile) blockAt(m mark) (lbrace, rbrace token.Pos) {
+	path := g.path(m)
+	for i := len(path) - 1; i >= 0; i-- {
+		switch n := path[i].(type) {
+		case *ast.BlockStmt:
+			return n.Lbrace, n.Rbrace
+		case *ast.CompositeLit:
+			return n.Lbrace, n.Rbrace
+		case *ast.StructType:
+			if n.Fields.Opening.IsValid() {
+				return n.Fields.Opening, n.Fields.Closing
+			}
+		case *ast.InterfaceType:
+			if n.Methods.Opening.IsValid() {
+				return n.Methods.Opening, n.Methods.Closing
+			}
+		}
+	}
+	return token.NoPos, token.NoPos
+}
+
+// nodeLines returns the first and last line of node n, its doc comment
+// included
+func (g *goFile) nodeLines(n ast.Node) (int, int) {
+	return g.line(nodeStart(n)), g.line(n.End() - 1)
+}
+
+// braces returns the region between the braces at lbrace and rbrace, as
+// bracketObject does
+func (g *goFile) braces(lbrace, rbrace token.Pos, around bool) region {
+	return bracketRegion(g.mark(lbrace), g.mark(rbrace), around)
+}
+
+// innerGoFunc is the body of the function around m, without its braces
+func innerGoFunc(m mark) (region, direction) {
+	g := parseGoBuffer(m.buf)
+	if g == nil {
+		return region{m, m}, right
+	}
+	if _, body := g.funcAt(m); body != nil && body.Rbrace.IsValid() {
+		return g.braces(body.Lbrace, body.Rbrace, false), right
+	}
+	return region{m, m}, right
+}
+
+func aGoFunc(m mark) (region, direction) {
+	return m.buf.linesRegion(aGoFuncLines(m)), right
+}
+
+// aGoFuncLines returns the lines of the function around m with its doc
+// comment
+func aGoFuncLines(m mark) (int, int) {
+	g := parseGoBuffer(m.buf)
+	if g == nil {
+		return m.line, m.line - 1
+	}
+	if f, _ := g.funcAt(m); f != nil {
+		return g.nodeLines(f)
+	}
+	return m.line, m.line - 1
+}
+
+// innerGoType is the type of the type declaration around m, only its fields
+// or methods for structs and interfaces
+func innerGoType(m mark) (region, direction) {
+	g := parseGoBuffer(m.buf)
+	if g == nil {
+		return region{m, m}, right
+	}
+	spec, _ := g.typeAt(m)
+	if spec == nil || spec.Type == nil {
+		return region{m, m}, right
+	}
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		if t.Fields.Closing.IsValid() {
+			return g.braces(t.Fields.Opening, t.Fields.Closing, false), right
+		}
+	case *ast.InterfaceType:
+		if t.Methods.Closing.IsValid() {
+			return g.braces(t.Methods.Opening, t.Methods.Closing, false), right
+		}
+	}
+	return region{g.mark(spec.Type.Pos()), g.mark(spec.Type.End())}, right
+}
+
+func aGoType(m mark) (region, direction) {
+	return m.buf.linesRegion(aGoTypeLines(m)), right
+}
+
+// aGoTypeLines returns the lines of the type declaration around m with its
+// doc comment, only the ones of the type in a group of declarations
+func aGoTypeLines(m mark) (int, int) {
+	g := parseGoBuffer(m.buf)
+	if g == nil {
+		return m.line, m.line - 1
+	}
+	if _, n := g.typeAt(m); n != nil {
+		return g.nodeLines(n)
+	}
+	return m.line, m.line - 1
+}
+
+// goBlock returns the region of the innermost brace block around m; outside
+// Go buffers it is the one of the {} text object
+func goBlock(m mark, around bool) region {
+	g := parseGoBuffer(m.buf)
+	if g == nil {
+		return bracketObject(m, '{', '}', around)
+	}
+	if lbrace, rbrace := g.blockAt(m); rbrace.IsValid() {
+		return g.braces(lbrace, rbrace, around)
+	}
+	return region{m, m}
+}
+
+func innerGoBlock(m mark) (region, direction) {
+	return goBlock(m, false), right
+}
+
+func aGoBlock(m mark) (region, direction) {
+	return goBlock(m, true), right
+}
+
+// declStarts returns the marks of the top level declarations of the buffer
+// of m; outside Go buffers they are the lines starting with { like in vi
+func declStarts(m mark) (starts []mark) {
+	g := parseGoBuffer(m.buf)
+	if g == nil {
+		for ln := 0; ln < m.buf.text.len(); ln++ {
+			if m.buf.text.line(ln)[0] == '{' {
+				starts = append(starts, mark{ln, 0, m.buf})
+			}
+		}
+		return starts
+	}
+	for _, d := range g.file.Decls {
+		starts = append(starts, g.mark(d.Pos()))
+	}
+	return starts
+}
+
+// toNextDecl moves to the start of the next top level declaration
+func toNextDecl(m mark) (region, direction) {
+	for _, s := range declStarts(m) {
+		if m.isBefore(s) {
+			return region{m, s}, right
+		}
+	}
+	return region{m, m}, right
+}
+
+// toPrevDecl moves to the start of the previous top level declaration
+func toPrevDecl(m mark) (region, direction) {
+	starts := declStarts(m)
+	for i := len(starts) - 1; i >= 0; i-- {
+		if starts[i].isBefore(m) {
+			return region{m, starts[i]}, left
+		}
+	}
+	return region{m, m}, left
+}
@@ -1,89 +1,300 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
 	"os/exec"
-	"regexp"
-)
-
-var (
-	openBlock     = regexp.MustCompile(`[{(:][[:space:]]*$`)
-	closeBlock    = regexp.MustCompile(`^[[:space:]]*[})]`)
-	caseStatement = regexp.MustCompile(`^[[:space:]]*(?:case .*)|(?:default):$`)
+	"path/filepath"
+	"strings"
 )
 
 func init() {
-	indentFuncs[_go] = goindent
-	indentKeys[_go] = []rune{')', '}', ':'}
+	info := _go.info()
+	info.indent, info.indentRegion = goindent, goIndents
+	info.indentKeys = []rune{')', '}', ':'}
+	info.beforeSave = append(info.beforeSave, func(v *view) error {
+		_, err := formatBuffer(v)
+		return err
+	})
 	commandModeFuncs["gofmt"] = gofmt
-	beforeSaveHooks.add(_go, func(v *view) { gofmt(v, nil) })
 }
 
-// goIndent returns the indentation needed for the line under the mark
+// goindent returns the indentation needed for the line under the mark
 func goindent(m *mark) (indent int) {
-	// indent first line in text with no indentation
-	if m.line == 0 {
-		return 0
-	}
+	return goIndents(m.buf, m.line, m.line)[0]
+}
 
-	// previous and current line without final newline char
-	prev := stripCommentsAndNewline(m.buf.text[m.line-1])
-	curr := stripCommentsAndNewline(m.buf.text[m.line])
+// goToken is a token of Go source with the line where it starts
+type goToken struct {
+	tok       token.Token
+	lit       string
+	line      int // from 0
+	off, end  int // the offsets of its first char and after its last
+	multiline bool
+}
 
-	// we start from previous line indent
-	indent, _ = lineIndent(m.buf, m.line-1)
+// goIndents returns the indentation of the lines from l1 to l2 included as
+// gofmt would do it, computed from the tokens of the text up to l2: one level
+// for each line with brackets still open, one less for case clauses and
+// labels, one more for the continuation of an expression. Lines inside raw
+// strings and comments are not to be re-indented and get -1
+func goIndents(b *buffer, l1, l2 int) []int {
+	var src []byte
+	lineStarts := make([]int, l2+2)
+	for i, ln := range b.text.lines(0, l2+1) {
+		lineStarts[i] = len(src)
+		src = append(src, string(ln)...)
+	}
+	lineStarts[l2+1] = len(src)
+	tokens := goTokens(src)
 
-	// indent line after new block
-	if openBlock.Match(prev.toBytes()) {
-		indent += tabStop
+	indents := make([]int, l2-l1+1)
+	setIndent := func(ln, level int) {
+		if ln >= l1 && ln <= l2 && indents[ln-l1] >= 0 {
+			indents[ln-l1] = level * b.tabStop
+		}
 	}
-	// indent closing block line
-	if closeBlock.Match(curr.toBytes()) {
-		indent -= tabStop
+	// lines inside multiline tokens are left alone
+	for _, t := range tokens {
+		if t.multiline {
+			for ln := t.line + 1; ln <= l2 && lineStarts[ln] < t.end; ln++ {
+				if ln >= l1 && lineStarts[ln] > t.off {
+					indents[ln-l1] = -1
+				}
+			}
+		}
 	}
-	//outindent back case: or default: in switch statement
-	if caseStatement.Match(curr.toBytes()) {
-		indent -= tabStop
+
+	var open []int // the lines of the brackets still open
+	level := func() (n int) {
+		for i := range open {
+			if i == 0 || open[i] != open[i-1] {
+				n++
+			}
+		}
+		return n
 	}
-	if indent < 0 {
-		indent = 0
+	cont := false    // true if the last line ended within an expression
+	outdent := false // true if the expression started on an outdented line
+	contLevel := func() int {
+		if cont && !outdent {
+			return level() + 1
+		}
+		return level()
 	}
-	return indent
+	line := -1 // the last line with a token
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.line > line {
+			// the lines without tokens before this one
+			for ln := line + 1; ln < t.line; ln++ {
+				setIndent(ln, contLevel())
+			}
+			line = t.line
+			// the closing brackets at the start of a line close the levels
+			// they opened before it is indented
+			for ; i < len(tokens) && tokens[i].line == line && isClosing(tokens[i].tok); i++ {
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+				cont = false
+			}
+			if !cont {
+				outdent = false
+			}
+			n := level()
+			switch {
+			case i < len(tokens) && tokens[i].line == line && isCaseOrLabel(tokens, i):
+				n--
+				outdent = true
+			case i == len(tokens) || tokens[i].line > line || tokens[i].tok != token.COMMENT:
+				n = contLevel()
+			}
+			if n < 0 {
+				n = 0
+			}
+			setIndent(line, n)
+			if i == len(tokens) || tokens[i].line > line {
+				i--
+				continue
+			}
+			t = tokens[i]
+		}
+		switch t.tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			open = append(open, t.line)
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		}
+		if t.tok != token.COMMENT {
+			cont = isContinuation(t)
+		}
+	}
+	for ln := line + 1; ln <= l2; ln++ {
+		setIndent(ln, contLevel())
+	}
+	return indents
 }
 
-//  stripCommentsAndNewlinereturns the line without end comments and newline
-func stripCommentsAndNewline(ln line) line {
-	for i, r := range ln {
-		switch r {
-		case '/':
-			if ln[i+1] == '/' {
-				return ln[:i]
-			}
-		case '\n':
-			return ln[:i]
+// goTokens returns the tokens of src, including comments but not the
+// semicolons inserted at the end of lines; the tokens following a raw string
+// or a comment on the line where it ends are given the line where it starts,
+// as they continue it
+func goTokens(src []byte) (tokens []goToken) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	// errors such as unterminated strings do not stop the scan
+	s.Init(file, src, nil, scanner.ScanComments)
+	joined, joinedTo := -1, -1 // the line ending a multiline token and its start
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return tokens
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		off := file.Offset(pos)
+		end := off + len(lit)
+		if lit == "" {
+			end = off + len(tok.String())
+		}
+		ln := file.Line(pos) - 1
+		if ln == joined {
+			ln = joinedTo
 		}
+		multiline := ((tok == token.STRING && strings.HasPrefix(lit, "`")) ||
+			(tok == token.COMMENT && strings.HasPrefix(lit, "/*"))) &&
+			strings.Contains(lit, "\n")
+		if multiline {
+			joined, joinedTo = file.Line(file.Pos(end-1))-1, ln
+		}
+		tokens = append(tokens, goToken{tok, lit, ln, off, end, multiline})
+	}
+}
+
+func isClosing(tok token.Token) bool {
+	return tok == token.RPAREN || tok == token.RBRACK || tok == token.RBRACE
+}
+
+// isCaseOrLabel returns true if tokens[i] starts a case clause or a label,
+// which are outdented
+func isCaseOrLabel(tokens []goToken, i int) bool {
+	switch tokens[i].tok {
+	case token.CASE, token.DEFAULT:
+		return true
+	case token.IDENT:
+		// a label is alone on its line
+		return i+1 < len(tokens) && tokens[i+1].tok == token.COLON &&
+			tokens[i+1].line == tokens[i].line &&
+			(i+2 == len(tokens) || tokens[i+2].line > tokens[i].line ||
+				tokens[i+2].tok == token.COMMENT)
+	}
+	return false
+}
+
+// isContinuation returns true if a line ending with t continues on the next
+// one, as after a binary operator
+func isContinuation(t goToken) bool {
+	switch t.tok {
+	case token.LPAREN, token.LBRACK, token.LBRACE, token.RPAREN, token.RBRACK,
+		token.RBRACE, token.COMMA, token.SEMICOLON, token.COLON, token.ELLIPSIS,
+		token.INC, token.DEC:
+		return false
 	}
-	return ln
+	return t.tok.IsOperator()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 // gofmt runs goimports (or gofmt if goimports is not installed) on view v
 func gofmt(v *view, args []string) string {
-	path, err := exec.LookPath("goimports")
-	program := "goimports"
+	program, err := formatBuffer(v)
+	if err != nil {
+		return err.Error()
+	}
+	return program + " run"
+}
+
+// formatBuffer pipes the text of the buffer of view v to goimports (or
+// gofmt) and applies the formatted text as a single change, leaving the
+// buffer untouched if the program reports an error
+func formatBuffer(v *view) (program string, err error) {
+	program = "goimports"
+	path, err := exec.LookPath(program)
+	args := []string{"-srcdir", filepath.Dir(v.buf.filename)}
 	if err != nil {
-		path, err = exec.LookPath("gofmt")
-		program = "gofmt"
-		if err != nil {
-			return "gofmt is not installed, sorry!"
+		program, args = "gofmt", nil
+		if path, err = exec.LookPath(program); err != nil {
+			return program, errors.New("gofmt is not installed, sorry!")
 		}
 	}
-	cmd := exec.Command(path, v.buf.filename)
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = &bufReader{buf: v.buf}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 	out, err := cmd.Output()
 	if err != nil {
-		debug.Printf("%v error: %v\n", program, err)
-		return "gofmt error, sorry!"
+		debug.Printf("%v error: %v %s\n", program, err, stderr.Bytes())
+		msg := strings.SplitN(stderr.String(), "\n", 2)[0]
+		if msg == "" {
+			return program, fmt.Errorf("%v error: %v", program, err)
+		}
+		// the errors refer to the file being edited, not to stdin
+		return program, errors.New(strings.Replace(msg, "<standard input>",
+			filepath.Base(v.buf.filename), 1))
+	}
+	v.buf.replaceText(ropeFromBytes(out).all(), v.cs)
+	return program, nil
+}
+
+// replaceText replaces the text of buffer b with lines changing only the
+// lines that differ, as a single change in the changeList; the cursor cs and
+// the marks of the buffer follow the lines they are on
+func (b *buffer) replaceText(lines []line, cs *mark) {
+	old := b.text.all()
+	a, n := make([]string, len(old)), make([]string, len(lines))
+	for i, ln := range old {
+		a[i] = string(ln)
+	}
+	for i, ln := range lines {
+		n[i] = string(ln)
+	}
+	hunks := diffLines(a, n)
+	if len(hunks) == 0 {
+		return
+	}
+	l1, l2 := hunks[0].from, hunks[len(hunks)-1].to-1
+	if l2 < l1 {
+		// only lines inserted, the change must include a line
+		if l1 < len(old) {
+			l2 = l1
+		} else {
+			l1, l2 = l1-1, l1-1
+		}
+	}
+	record := b.recordLinesChange(l1, l2)
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		b.text.replace(h.from, h.to, lines[h.newFrom:h.newTo]...)
+	}
+	record()
+	cs.line = mapLine(hunks, cs.line)
+	cs.fixLineAndPos()
+	for name, m := range b.marks {
+		m.line = mapLine(hunks, m.line)
+		m.fixLineAndPos()
+		b.marks[name] = m
 	}
-	v.buf.text = bytesToText(out)
-	// make sure cursor is OK
-	v.cs.fixLineAndPos()
-	return program + " run"
 }
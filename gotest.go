@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+func init() {
+	commandModeFuncs["gotest"] = goTest
+	cmdStringNormalMode[",t"] = command{goTestAtCursor, nil}
+	cmdKeyNormalMode[KeyF5] = command{goTestAgain, nil}
+}
+
+// testFuncRE matches the names of the functions run by go test
+var testFuncRE = regexp.MustCompile(`^(Test|Benchmark)([^\p{Ll}].*)?$`)
+
+// testRun is a run of go test: its directory and its args
+type testRun struct {
+	dir  string
+	args []string
+}
+
+// testOutputs holds the buffer showing the output of the last go test and
+// the run to repeat
+type testOutputs struct {
+	mu   sync.Mutex
+	buf  *buffer
+	last *testRun
+}
+
+var testOutput = &testOutputs{}
+
+func (t *testOutputs) buffer() *buffer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf
+}
+
+func (t *testOutputs) lastRun() *testRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// goTest runs the test function around the cursor, the tests of the file
+// with ":gotest file" or the ones of the package with ":gotest package"
+func goTest(v *view, args []string) (msg string) {
+	scope := "func"
+	if len(args) > 0 {
+		scope = args[0]
+	}
+	var tests, benchmarks []string
+	switch scope {
+	case "func":
+		name := testFuncAt(v)
+		if name == "" {
+			return "No test function at the cursor"
+		}
+		if strings.HasPrefix(name, "Benchmark") {
+			benchmarks = []string{name}
+		} else {
+			tests = []string{name}
+		}
+	case "file":
+		tests, benchmarks = testFuncs(v.buf)
+		if len(tests)+len(benchmarks) == 0 {
+			return "No test functions in the file"
+		}
+	case "package", "pkg":
+	default:
+		return "Usage: gotest [file|package]"
+	}
+	run := &testRun{filepath.Dir(v.buf.filename), testArgs(tests, benchmarks)}
+	return run.start(v)
+}
+
+// goTestAtCursor runs the test function around the cursor
+func goTestAtCursor(ctx *cmdContext) {
+	ctx.msg = goTest(ctx.view, nil)
+}
+
+// goTestAgain repeats the last run of go test
+func goTestAgain(ctx *cmdContext) {
+	run := testOutput.lastRun()
+	if run == nil {
+		ctx.msg = "No previous test run"
+		return
+	}
+	ctx.msg = run.start(ctx.view)
+}
+
+// testArgs returns the args of go test running exactly the functions named
+// in tests and benchmarks, or all the tests of the package if there are none
+func testArgs(tests, benchmarks []string) []string {
+	args := []string{"test"}
+	match := func(names []string) string {
+		return "^(" + strings.Join(names, "|") + ")$"
+	}
+	switch {
+	case len(tests) > 0:
+		args = append(args, "-run", match(tests))
+	case len(benchmarks) > 0:
+		args = append(args, "-run", "^$")
+	}
+	if len(benchmarks) > 0 {
+		args = append(args, "-bench", match(benchmarks))
+	}
+	return append(args, ".")
+}
+
+// testFuncAt returns the name of the test or benchmark function around the
+// cursor of v, "" if there is none
+func testFuncAt(v *view) string {
+	g := parseGoBuffer(v.buf)
+	if g == nil {
+		return ""
+	}
+	for _, n := range g.path(*v.cs) {
+		if f, ok := n.(*ast.FuncDecl); ok && f.Recv == nil && testFuncRE.MatchString(f.Name.Name) {
+			return f.Name.Name
+		}
+	}
+	return ""
+}
+
+// testFuncs returns the names of the test and benchmark functions of b
+func testFuncs(b *buffer) (tests, benchmarks []string) {
+	g := parseGoBuffer(b)
+	if g == nil {
+		return nil, nil
+	}
+	for _, d := range g.file.Decls {
+		f, ok := d.(*ast.FuncDecl)
+		if !ok || f.Recv != nil || !testFuncRE.MatchString(f.Name.Name) {
+			continue
+		}
+		if strings.HasPrefix(f.Name.Name, "Benchmark") {
+			benchmarks = append(benchmarks, f.Name.Name)
+		} else {
+			tests = append(tests, f.Name.Name)
+		}
+	}
+	return tests, benchmarks
+}
+
+// start runs go test in the background, streaming its output to the test
+// buffer shown below v; the failures fill the quickfix list
+func (run *testRun) start(v *view) string {
+	cmd := exec.Command("go", run.args...)
+	cmd.Dir = run.dir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err.Error()
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err.Error()
+	}
+	cmdLine := "go " + strings.Join(run.args, " ")
+	b := showTestOutput(v, run, cmdLine)
+
+	wait <- struct{}{}
+	go func() {
+		defer func() { <-wait }()
+		var out bytes.Buffer
+		s := bufio.NewScanner(stdout)
+		for s.Scan() {
+			out.Write(s.Bytes())
+			out.WriteByte('\n')
+			// the lines are added to the buffer by commands, in order
+			ln := stringToLine(s.Text() + "\n")
+			postCmd(func(ctx *cmdContext) {
+				ctx.silent = true
+				b.text.insert(b.text.len(), ln)
+				ui.Draw()
+			})
+		}
+		err := cmd.Wait()
+		entries := parseTestOutput(run.dir, out.Bytes())
+		<-postCmd(func(ctx *cmdContext) {
+			r.quickfix.set(entries)
+			switch {
+			case err == nil:
+				ctx.msg = "gotest: ok"
+			case len(entries) > 0:
+				ctx.msg = fmt.Sprintf("gotest: %v failures", len(entries))
+			default:
+				ctx.msg = "gotest: " + err.Error()
+			}
+		})
+	}()
+	return cmdLine
+}
+
+// showTestOutput empties the test buffer for run, showing it in a new pane
+// below v the first time, and returns it
+func showTestOutput(v *view, run *testRun, cmdLine string) *buffer {
+	testOutput.mu.Lock()
+	defer testOutput.mu.Unlock()
+	testOutput.last = run
+	b := testOutput.buf
+	if b != nil {
+		b.text = newRope([]line{stringToLine(cmdLine + "\n")})
+		return b
+	}
+	b = newListBuffer("[Test]", testOutputJump)
+	b.text = newRope([]line{stringToLine(cmdLine + "\n")})
+	testOutput.buf = b
+	ui.SplitHorizontal()
+	if cv := ui.CurrentView(); cv != nil && cv != v {
+		cv.moveTo(mark{0, 0, b})
+		ui.ToPane(up)
+	}
+	return b
+}
+
+// parseTestOutput returns the locations of the output of go test, where
+// they are indented under the test they belong to
+func parseTestOutput(dir string, out []byte) []quickfixEntry {
+	lines := bytes.Split(out, []byte("\n"))
+	for i, ln := range lines {
+		lines[i] = bytes.TrimLeft(ln, " \t")
+	}
+	return parseFileLines(dir, bytes.Join(lines, []byte("\n")))
+}
+
+// testOutputJump jumps to the location on the cursor line of the test
+// buffer, in the pane above
+func testOutputJump(ctx *cmdContext) {
+	run := testOutput.lastRun()
+	ln := ctx.point.buf.text.line(ctx.point.line)
+	entries := parseTestOutput(run.dir, []byte(string(ln)))
+	if len(entries) == 0 {
+		return
+	}
+	e := entries[0]
+	v := ctx.view
+	ui.ToPane(up)
+	if cv := ui.CurrentView(); cv != nil {
+		v = cv
+	}
+	b, err := be.bufferFor(e.file)
+	if err != nil {
+		ctx.msg = err.Error()
+		return
+	}
+	v.moveTo(markAtByte(b, e.line-1, e.col-1))
+	ctx.msg = e.msg
+}
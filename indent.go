@@ -1,18 +1,23 @@
 package main
 
-type indentFunc func(m *mark) (indent int)
+import "strings"
 
-var (
-	tab     = []rune{'\t'}
-	tabStop = 4
-)
+type indentFunc func(m *mark) (indent int)
 
-var indentFuncs = map[filetype]indentFunc{}
+// tab returns the chars of a level of indentation in buffer b
+func (b *buffer) tab() line {
+	if b.expandTab {
+		return line(strings.Repeat(" ", b.tabStop))
+	}
+	return line{'\t'}
+}
 
-var indentKeys = map[filetype][]rune{}
+// indentRegionFunc returns the indentation of the lines from l1 to l2
+// included, -1 for the lines to leave unchanged
+type indentRegionFunc func(b *buffer, l1, l2 int) []int
 
 func isIndentKey(r rune, b *buffer) bool {
-	for _, k := range indentKeys[b.filetype] {
+	for _, k := range b.filetype.info().indentKeys {
 		if k == r {
 			return true
 		}
@@ -24,10 +29,8 @@ func isIndentKey(r rune, b *buffer) bool {
 // file type indent func if present or simply the prevoius line indent; it then
 // returns the change in indentation chars to move the cursor if needed
 func (m *mark) indentLine() (indentChars int) {
-
-	_, currIndentChars := lineIndent(m.buf, m.line)
 	// determine indentation
-	f := indentFuncs[m.buf.filetype]
+	f := m.buf.filetype.info().indent
 	var indent int
 	switch {
 	case f != nil:
@@ -37,26 +40,70 @@ func (m *mark) indentLine() (indentChars int) {
 	default:
 		indent, _ = lineIndent(m.buf, m.line-1)
 	}
+	return m.buf.setIndent(m.line, indent)
+}
+
+// indentInsertedLine re-indents the line of m in insertMode, the change of
+// indentation is recorded with the text inserted; it returns the change in
+// indentation chars like indentLine
+func (m *mark) indentInsertedLine() (indentChars int) {
+	_, currIndentChars := lineIndent(m.buf, m.line)
+	fr, to := mark{m.line, 0, m.buf}, mark{m.line, currIndentChars, m.buf}
+	m.buf.editInsert(fr, to, func() { indentChars = m.indentLine() })
+	return indentChars
+}
 
-	tabs, spaces := indent/tabStop, indent%tabStop
+// setIndent replaces the indentation of line ln with indent columns, made of
+// tabs then spaces, or only of spaces if the buffer expands tabs, and returns
+// the change in indentation chars; a negative indent leaves the line
+// unchanged
+func (b *buffer) setIndent(ln, indent int) (indentChars int) {
+	if indent < 0 {
+		return 0
+	}
+	_, currIndentChars := lineIndent(b, ln)
+	tabs, spaces := indent/b.tabStop, indent%b.tabStop
+	if b.expandTab {
+		tabs, spaces = 0, indent
+	}
 	indentRunes := line{}
 	for i := 0; i < tabs; i++ {
-		indentRunes = append(indentRunes, tab...)
+		indentRunes = append(indentRunes, '\t')
 	}
 	for i := 0; i < spaces; i++ {
 		indentRunes = append(indentRunes, ' ')
 	}
-	_, oldIndent := lineIndent(m.buf, m.line)
-	m.buf.text[m.line] = append(indentRunes, m.buf.text[m.line][oldIndent:]...)
+	b.text.setLine(ln, append(indentRunes, b.text.line(ln)[currIndentChars:]...))
 	return tabs + spaces - currIndentChars
 }
 
+// indentLines re-indents the lines from l1 to l2 included as one change;
+// empty lines are left alone
+func (b *buffer) indentLines(l1, l2 int) {
+	defer b.recordLinesChange(l1, l2)()
+	var indents []int
+	if f := b.filetype.info().indentRegion; f != nil {
+		indents = f(b, l1, l2)
+	}
+	for ln := l1; ln <= l2; ln++ {
+		if len(b.text.line(ln)) == 1 {
+			continue
+		}
+		if indents != nil {
+			b.setIndent(ln, indents[ln-l1])
+		} else {
+			m := mark{ln, 0, b}
+			m.indentLine()
+		}
+	}
+}
+
 // indent returns the indentation of the line and the numbers of indent chars
 func lineIndent(b *buffer, ln int) (indent, indentChars int) {
-	for _, r := range b.text[ln] {
+	for _, r := range b.text.line(ln) {
 		switch r {
 		case '\t':
-			indent += tabStop
+			indent += b.tabStop
 			indentChars++
 		case ' ':
 			indent += 1
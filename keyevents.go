@@ -3,6 +3,7 @@ package main
 import (
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 )
@@ -30,12 +31,72 @@ func pushCmd(ctx *cmdContext) {
 
 var cmdDone = struct{}{}
 
+// postedCmd is a command posted by a goroutine working in the background;
+// done is closed once it is executed
+type postedCmd struct {
+	cmd  cmdFunc
+	done chan struct{}
+}
+
+// asyncCmds holds the posted commands waiting to be executed; ready gets a
+// value when there are some
+var asyncCmds = struct {
+	sync.Mutex
+	list  []postedCmd
+	ready chan struct{}
+}{ready: make(chan struct{}, 1)}
+
+// postCmd has cmd executed by the goroutine parsing the keys, between the
+// commands of the keys, so that the goroutines working in the background,
+// like the language servers or :make, change the buffers and the editor
+// state safely. It does not wait; the channel returned is closed once cmd
+// is done
+func postCmd(cmd cmdFunc) <-chan struct{} {
+	pc := postedCmd{cmd, make(chan struct{})}
+	asyncCmds.Lock()
+	asyncCmds.list = append(asyncCmds.list, pc)
+	asyncCmds.Unlock()
+	select {
+	case asyncCmds.ready <- struct{}{}:
+	default:
+	}
+	return pc.done
+}
+
+// runPostedCmds executes the posted commands in view v
+func runPostedCmds(v *view, cmds chan cmdContext) {
+	asyncCmds.Lock()
+	list := asyncCmds.list
+	asyncCmds.list = nil
+	asyncCmds.Unlock()
+	if v == nil {
+		v = ui.CurrentView()
+	}
+	for _, pc := range list {
+		// without a point the command is not recorded as a change to repeat
+		pushCmd(&cmdContext{cmd: pc.cmd, view: v,
+			cmdChans: cmdStack{cmds, make(chan struct{}, 1)}})
+		close(pc.done)
+	}
+}
+
 func executeCommands(cmds chan cmdContext) {
 	for {
 		ctx := <-cmds
 		go func() {
 			defer cleanupOnError()
+			st := r.changes.before(&ctx)
 			ctx.cmd(&ctx)
+			r.changes.after(&ctx, st)
+			if st.buf != nil {
+				st.buf.updateModified()
+			}
+			if b := st.buf; b != nil && ctx.view != nil && b.mod != insertMode &&
+				(st.mod == insertMode || st.text != b.text || st.version != b.text.version) {
+				if err := runAfterChange(ctx.view); err != nil {
+					debug.Printf("after change hooks: %v", err)
+				}
+			}
 			if !ctx.silent {
 				be.msgLine = line(ctx.msg)
 				ui.Draw()
@@ -62,12 +123,18 @@ func manageKeypress(keys chan UIEvent, cmds chan cmdContext) {
 		default:
 			select {
 			case ev = <-keys:
-				ctx.point = ev.View.cs
+				ctx.view, ctx.point = ev.View, ev.View.cs
 				if r.macros.on {
 					r.macros.record(ev.Key)
 				}
+			case <-asyncCmds.ready:
+				runPostedCmds(ctx.view, cmds)
+				continue
 			case <-time.After(keypressTimeout):
 				ev.Type = UIEventTimeout
+				if !be.CommandMode() {
+					postCmd(pollFiles)
+				}
 			}
 		}
 		nextParser, reconsumeEvent = nextParser(&ev, ctx)
@@ -82,11 +149,53 @@ func manageKeypress(keys chan UIEvent, cmds chan cmdContext) {
 	}
 }
 
+// runKeys executes keys in view v as if they were typed and ends in
+// normalMode. It is called while parsing a keypress, as the commands it
+// executes wait for the commands goroutine
+func runKeys(v *view, keys []Keypress) {
+	defer func(commandMode bool) { be.commandMode = commandMode }(be.commandMode)
+	be.commandMode = false
+	newContext := func() *cmdContext {
+		return &cmdContext{view: v, point: v.cs,
+			cmdChans: cmdStack{commands, make(chan struct{}, 1)}}
+	}
+	events := make([]UIEvent, 0, len(keys)+1)
+	for _, k := range keys {
+		events = append(events, UIEvent{View: v, Type: UIEventKey, Key: k})
+	}
+	// the timeout executes the command still waiting for keys, if complete
+	events = append(events, UIEvent{View: v, Type: UIEventTimeout})
+	var nextParser parseFunc = parseAction
+	ctx := newContext()
+	for i := 0; i < len(events); {
+		var reconsumeEvent bool
+		nextParser, reconsumeEvent = nextParser(&events[i], ctx)
+		if !reconsumeEvent {
+			i++
+		}
+		if nextParser == nil {
+			ctx, nextParser = newContext(), parseAction
+		}
+	}
+	if v.buf.mod != normalMode {
+		ctx = newContext()
+		if ctx.cmd = lookupKeyCmd(v.buf.mod, KeyEsc).cmd; ctx.cmd != nil {
+			pushCmd(ctx)
+		}
+	}
+}
+
 func parseAction(ev *UIEvent, ctx *cmdContext) (
 	nextParser parseFunc, reprocessEvent bool) {
 	if be.commandMode == true {
 		return parseCommandMode(ev, ctx)
 	}
+	if be.searchMode == true {
+		return parseSearchMode(ev, ctx)
+	}
+	if be.confirm != nil {
+		return parseConfirm(ev, ctx)
+	}
 	switch {
 	// if called by a timeout execute a matched string command if we have one
 	case ev.Type == UIEventTimeout:
@@ -116,6 +225,8 @@ func parseAction(ev *UIEvent, ctx *cmdContext) (
 	case isNumber(ev.Key.Char, ctx) && ctx.cmdString == "":
 		loadNumber(ev.Key.Char, ctx)
 		return parseAction, false
+	case ev.Key.Char == '"' && ctx.cmdString == "" && ev.View.buf.mod != insertMode:
+		return parseRegisterName, false
 	default:
 		m := ev.View.buf.mod
 		ctx.char = ev.Key.Char
@@ -131,7 +242,7 @@ func parseAction(ev *UIEvent, ctx *cmdContext) (
 		case len(submatches) == 0:
 			// if no matches, we check if we had a valid command before this char
 			// and if so execute the command and reprocess the char
-			if m == normalMode {
+			if m == normalMode || m == visualMode {
 				c = lookupStringCmd(ev.View.buf.mod, ctx.cmdString[:len(ctx.cmdString)-1])
 				if c.cmd != nil {
 					return checkCmd(c, ctx), true
@@ -149,6 +260,21 @@ func parseAction(ev *UIEvent, ctx *cmdContext) (
 	}
 }
 
+// parseRegisterName reads the name of the register to be used by the command
+// that follows
+func parseRegisterName(ev *UIEvent, ctx *cmdContext) (
+	nextParser parseFunc, reprocessEvent bool) {
+	switch {
+	case ev.Type == UIEventTimeout:
+		return parseRegisterName, false
+	case ev.Key.isSpecial || !isRegisterName(ev.Key.Char):
+		return nil, false
+	default:
+		ctx.register = ev.Key.Char
+		return parseAction, false
+	}
+}
+
 func deleteCommandChars(ctx *cmdContext) {
 	ctx.cmd = deleteCharBackward
 	ctx.silent = true
@@ -211,6 +337,8 @@ func parseRegion(ev *UIEvent, ctx *cmdContext) (
 	case isNumber(ev.Key.Char, ctx) && ctx.argString == "":
 		loadNumber(ev.Key.Char, ctx)
 		return parseRegion, false
+	case (ev.Key.Char == '/' || ev.Key.Char == '?') && ctx.argString == "":
+		return parseSearchRegion(ev, ctx)
 	default:
 		ctx.argString += string(ev.Key.Char)
 		match, subMatches := matchRegionFunc(ctx.argString, ctx.customList, regionFuncs)
@@ -263,7 +391,8 @@ func matchRegionFunc(s string, list []string, m map[string]regionFunc) (
 // and there is no number in ctx.num (that is the 0 is not there to complete
 // a number like 10 or 02)
 func isNumber(ch rune, ctx *cmdContext) bool {
-	if !unicode.IsDigit(ch) || ctx.point.buf.mod != normalMode {
+	mod := ctx.point.buf.mod
+	if !unicode.IsDigit(ch) || (mod != normalMode && mod != visualMode) {
 		return false
 	}
 	if ch == '0' && ctx.num == 0 {
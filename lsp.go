@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lspServer is the command line of the language server for Go files
+var lspServer = []string{"gopls"}
+
+// lspTimeout is how long we wait for the answer to a request
+var lspTimeout = 10 * time.Second
+
+// lspClients are the running language servers, by module root
+var lspClients = map[string]*lspClient{}
+
+// lspClient is a connection over stdio to a language server
+type lspClient struct {
+	root string
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+
+	mu      sync.Mutex // guards the fields below and the writes to in
+	nextID  int
+	pending map[int]chan *lspMessage // the requests waiting for an answer
+	docs    map[string]*lspDoc       // the files opened in the server, by path
+	err     error                    // set when the connection is lost
+}
+
+// lspDoc is the state of a file as known by the server
+type lspDoc struct {
+	version int   // the version number sent with the last text
+	text    *rope // the text sent last, and its version
+	textVer int
+}
+
+// lspMessage is a JSON-RPC request, response or notification
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return e.Message
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // in UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDocumentID struct {
+	URI string `json:"uri"`
+}
+
+type lspDocumentPosition struct {
+	TextDocument lspDocumentID `json:"textDocument"`
+	Position     lspPosition   `json:"position"`
+}
+
+func init() {
+	info := _go.info()
+	info.afterChange = append(info.afterChange, lspSyncChanges)
+}
+
+// lspClientFor returns the language server for the buffer b, starting it
+// if needed, after sending it the current text of b
+func lspClientFor(b *buffer) (*lspClient, error) {
+	if b.filetype != _go {
+		return nil, errors.New("No language server for this file")
+	}
+	root := moduleRoot(filepath.Dir(b.filename))
+	c := lspClients[root]
+	if c == nil || c.failed() != nil {
+		var err error
+		if c, err = startLSP(root); err != nil {
+			return nil, err
+		}
+		lspClients[root] = c
+	}
+	return c, c.sync(b)
+}
+
+// lspSyncChanges sends the changes of the buffer of v to its language
+// server, if one was started for it, so that the server can publish
+// diagnostics
+func lspSyncChanges(v *view) error {
+	for _, c := range lspClients {
+		if c.docs[v.buf.filename] != nil {
+			return c.sync(v.buf)
+		}
+	}
+	return nil
+}
+
+// moduleRoot returns the directory of the go.mod file for the files in dir,
+// or dir itself if there is none
+func moduleRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// startLSP starts a language server for the module at root and initializes
+// the connection
+func startLSP(root string) (*lspClient, error) {
+	cmd := exec.Command(lspServer[0], lspServer[1:]...)
+	cmd.Dir = root
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start %v: %v", lspServer[0], err)
+	}
+	c := &lspClient{
+		root:    root,
+		cmd:     cmd,
+		in:      in,
+		pending: make(map[int]chan *lspMessage),
+		docs:    make(map[string]*lspDoc),
+	}
+	go c.read(bufio.NewReader(out))
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI(root),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"hover": map[string]interface{}{
+					"contentFormat": []string{"plaintext", "markdown"},
+				},
+				"completion": map[string]interface{}{
+					"completionItem": map[string]interface{}{"snippetSupport": false},
+				},
+			},
+		},
+	}
+	if err := c.call("initialize", params, nil); err != nil {
+		c.stop()
+		return nil, fmt.Errorf("%v initialize: %v", lspServer[0], err)
+	}
+	return c, c.notify("initialized", struct{}{})
+}
+
+// stop shuts down the server
+func (c *lspClient) stop() {
+	if c.failed() == nil {
+		c.call("shutdown", nil, nil)
+		c.notify("exit", nil)
+	}
+	c.in.Close()
+	done := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		c.cmd.Process.Kill()
+	}
+}
+
+// stopLSPClients shuts down all the language servers
+func stopLSPClients() {
+	for root, c := range lspClients {
+		c.stop()
+		delete(lspClients, root)
+	}
+}
+
+// failed returns the error that broke the connection, if any
+func (c *lspClient) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// call sends a request and decodes its result in result, if not nil
+func (c *lspClient) call(method string, params, result interface{}) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *lspMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	rawID := json.RawMessage(strconv.Itoa(id))
+	if err := c.send(&lspMessage{ID: &rawID, Method: method}, params); err != nil {
+		return err
+	}
+	var resp *lspMessage
+	select {
+	case resp = <-ch:
+	case <-time.After(lspTimeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("%v: no answer from the language server", method)
+	}
+	switch {
+	case resp == nil:
+		return c.failed()
+	case resp.Error != nil:
+		return resp.Error
+	case result == nil || len(resp.Result) == 0:
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// notify sends a notification
+func (c *lspClient) notify(method string, params interface{}) error {
+	return c.send(&lspMessage{Method: method}, params)
+}
+
+// send writes message m with params to the server
+func (c *lspClient) send(m *lspMessage, params interface{}) error {
+	m.JSONRPC = "2.0"
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		m.Params = p
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	_, err = fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// read reads the messages from the server until the connection is lost
+func (c *lspClient) read(r *bufio.Reader) {
+	for {
+		m, err := readLSPMessage(r)
+		if err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("language server stopped: %v", err)
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+		switch {
+		case m.ID != nil && m.Method != "":
+			// we answer requests from the server with an empty result
+			c.send(&lspMessage{ID: m.ID, Result: json.RawMessage("null")}, nil)
+		case m.ID != nil:
+			id, _ := strconv.Atoi(string(*m.ID))
+			c.mu.Lock()
+			ch := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- m
+			}
+		default:
+			c.handleNotification(m)
+		}
+	}
+}
+
+// handleNotification handles a notification from the server
+func (c *lspClient) handleNotification(m *lspMessage) {
+	switch m.Method {
+	case "textDocument/publishDiagnostics":
+		var p struct {
+			URI         string `json:"uri"`
+			Diagnostics []struct {
+				Range    lspRange `json:"range"`
+				Severity severity `json:"severity"`
+				Message  string   `json:"message"`
+			} `json:"diagnostics"`
+		}
+		if err := json.Unmarshal(m.Params, &p); err != nil {
+			debug.Printf("lsp %v: %v", m.Method, err)
+			return
+		}
+		fp, err := uriFile(p.URI)
+		if err != nil {
+			return
+		}
+		// the positions are mapped with the text of the buffer, which only
+		// the commands can read safely
+		postCmd(func(ctx *cmdContext) {
+			ctx.silent = true
+			b := be.findBuffer(fp)
+			if b == nil {
+				return
+			}
+			diags := make([]diagnostic, len(p.Diagnostics))
+			for i, d := range p.Diagnostics {
+				if d.Severity == 0 {
+					d.Severity = sevError
+				}
+				diags[i] = diagnostic{markAt(b, d.Range.Start), markAt(b, d.Range.End),
+					d.Severity, d.Message}
+			}
+			b.diags.set("lsp", diags)
+			ui.Draw()
+		})
+	default:
+		debug.Printf("lsp %v: %s", m.Method, m.Params)
+	}
+}
+
+// readLSPMessage reads a message with its header
+func readLSPMessage(r *bufio.Reader) (*lspMessage, error) {
+	length := -1
+	for {
+		hdr, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		hdr = strings.TrimSpace(hdr)
+		if hdr == "" {
+			break
+		}
+		if v := strings.TrimPrefix(hdr, "Content-Length:"); v != hdr {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	m := &lspMessage{}
+	return m, json.Unmarshal(body, m)
+}
+
+// sync sends the text of b to the server if it changed since the last time,
+// opening the file the first time
+func (c *lspClient) sync(b *buffer) error {
+	doc := c.docs[b.filename]
+	switch {
+	case doc == nil:
+		doc = &lspDoc{version: 1}
+		c.docs[b.filename] = doc
+		err := c.notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri":        fileURI(b.filename),
+				"languageId": "go",
+				"version":    doc.version,
+				"text":       bufferString(b),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	case doc.text != b.text || doc.textVer != b.text.version:
+		doc.version++
+		err := c.notify("textDocument/didChange", map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"uri":     fileURI(b.filename),
+				"version": doc.version,
+			},
+			"contentChanges": []map[string]string{{"text": bufferString(b)}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	doc.text, doc.textVer = b.text, b.text.version
+	return nil
+}
+
+// bufferString returns the text of buffer b
+func bufferString(b *buffer) string {
+	var sb strings.Builder
+	io.Copy(&sb, &bufReader{buf: b})
+	return sb.String()
+}
+
+// fileURI returns the URI of the file at path fp
+func fileURI(fp string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(fp)}).String()
+}
+
+// uriFile returns the path of the file with URI uri
+func uriFile(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("not a file: %v", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// lspPos returns the position of mark m for the server
+func lspPos(m mark) lspPosition {
+	ln := m.buf.text.line(m.line)
+	n := 0
+	for _, ch := range ln[:m.pos] {
+		n += utf16Len(ch)
+	}
+	return lspPosition{m.line, n}
+}
+
+// markAt returns the mark in buffer b at position p from the server
+func markAt(b *buffer, p lspPosition) mark {
+	m := mark{p.Line, 0, b}
+	if m.line > m.lastLine() {
+		// past the end of the text, at its last char
+		m.line = m.lastLine()
+		m.pos = m.lineEndPos()
+		return m
+	}
+	ln := b.text.line(m.line)
+	for n := 0; m.pos < len(ln)-1 && n < p.Character; m.pos++ {
+		n += utf16Len(ln[m.pos])
+	}
+	return m
+}
+
+// utf16Len returns the number of UTF-16 code units encoding ch
+func utf16Len(ch rune) int {
+	if ch >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// documentPosition returns the position of the cursor of view v for a
+// request
+func documentPosition(v *view) lspDocumentPosition {
+	return lspDocumentPosition{lspDocumentID{fileURI(v.buf.filename)}, lspPos(*v.cs)}
+}
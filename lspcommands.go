@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	cmdStringNormalMode["gd"] = command{goToDefinition, nil}
+	cmdStringNormalMode["K"] = command{showHover, nil}
+	cmdKeyInsertMode[KeyCtrlN] = command{completeNext, nil}
+	cmdKeyInsertMode[KeyCtrlP] = command{completePrev, nil}
+	commandModeFuncs["def"] = definition
+	commandModeFuncs["hover"] = hover
+	commandModeFuncs["refs"] = references
+	commandModeFuncs["rename"] = rename
+}
+
+func goToDefinition(ctx *cmdContext) {
+	ctx.msg = definition(ctx.view, nil)
+}
+
+func showHover(ctx *cmdContext) {
+	ctx.msg = hover(ctx.view, nil)
+}
+
+// definition moves the cursor of view v to the definition of the identifier
+// under it, opening its file if needed
+func definition(v *view, args []string) (msg string) {
+	c, err := lspClientFor(v.buf)
+	if err != nil {
+		return err.Error()
+	}
+	var raw json.RawMessage
+	if err := c.call("textDocument/definition", documentPosition(v), &raw); err != nil {
+		return err.Error()
+	}
+	locs := parseLocations(raw)
+	if len(locs) == 0 {
+		return "No definition found"
+	}
+	m, err := locationMark(locs[0])
+	if err != nil {
+		return err.Error()
+	}
+	v.moveTo(m)
+	return ""
+}
+
+// parseLocations decodes the locations of a definition result, which can be
+// a location, a list of locations or a list of location links
+func parseLocations(raw json.RawMessage) (locs []lspLocation) {
+	var list []struct {
+		lspLocation
+		TargetURI            string   `json:"targetUri"`
+		TargetSelectionRange lspRange `json:"targetSelectionRange"`
+	}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var loc lspLocation
+		if err := json.Unmarshal(raw, &loc); err != nil || loc.URI == "" {
+			return nil
+		}
+		return []lspLocation{loc}
+	}
+	for _, l := range list {
+		if l.TargetURI != "" {
+			l.lspLocation = lspLocation{l.TargetURI, l.TargetSelectionRange}
+		}
+		locs = append(locs, l.lspLocation)
+	}
+	return locs
+}
+
+// locationMark returns the mark at the start of location loc, opening its
+// file if needed
+func locationMark(loc lspLocation) (mark, error) {
+	fp, err := uriFile(loc.URI)
+	if err != nil {
+		return mark{}, err
+	}
+	b, err := be.bufferFor(fp)
+	if err != nil {
+		return mark{}, err
+	}
+	return markAt(b, loc.Range.Start), nil
+}
+
+// hover returns the first line of the information about the identifier under
+// the cursor of view v
+func hover(v *view, args []string) (msg string) {
+	c, err := lspClientFor(v.buf)
+	if err != nil {
+		return err.Error()
+	}
+	var result struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if err := c.call("textDocument/hover", documentPosition(v), &result); err != nil {
+		return err.Error()
+	}
+	for _, ln := range strings.Split(hoverText(result.Contents), "\n") {
+		// we skip the fences of markdown code blocks
+		if ln = strings.TrimSpace(ln); ln != "" && !strings.HasPrefix(ln, "```") {
+			return ln
+		}
+	}
+	return "No information"
+}
+
+// hoverText decodes the contents of a hover result, which can be a markup
+// content, a marked string or a list of marked strings
+func hoverText(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var mc struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &mc); err == nil {
+		return mc.Value
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil && len(list) > 0 {
+		return hoverText(list[0])
+	}
+	return ""
+}
+
+// completion is the last item of a completion list inserted; Ctrl-N and
+// Ctrl-P right after it replace it with the next or the previous item
+var completion struct {
+	start, end mark     // where the item was inserted
+	items      []string // the texts of the items of the list
+	i          int      // the item inserted
+	text       *rope    // the text of the buffer once inserted
+	version    int      // the version of text once inserted
+}
+
+func completeNext(ctx *cmdContext) {
+	ctx.msg = complete(ctx.view, 1)
+}
+
+func completePrev(ctx *cmdContext) {
+	ctx.msg = complete(ctx.view, -1)
+}
+
+// complete replaces the identifier before the cursor of view v, in
+// insertMode, with the first (dir > 0) or the last item of the completion
+// list of the server; right after a completion it moves to the next or the
+// previous item instead
+func complete(v *view, dir int) string {
+	c, b := &completion, v.buf
+	if len(c.items) == 0 || c.end != *v.cs || c.text != b.text || c.version != b.text.version {
+		items, err := completionItems(v)
+		if err != nil {
+			return err.Error()
+		}
+		c.items = items
+		if len(items) == 0 {
+			return "No completions"
+		}
+		c.start, c.end, c.i = *v.cs, *v.cs, -1
+		if dir < 0 {
+			c.i = 0
+		}
+		ln := b.text.line(c.start.line)
+		for c.start.pos > 0 && isWordChar(ln[c.start.pos-1]) {
+			c.start.pos--
+		}
+	}
+	c.i = (c.i + dir + len(c.items)) % len(c.items)
+	item := stringToLine(c.items[c.i])
+	b.editInsert(c.start, c.end, func() {
+		region{c.start, c.end}.replace(text{item})
+	})
+	c.end = mark{c.start.line, c.start.pos + len(item), b}
+	c.text, c.version = b.text, b.text.version
+	*v.cs = c.end
+	return fmt.Sprintf("match %v of %v", c.i+1, len(c.items))
+}
+
+// completionItems returns the texts to insert of the items the server
+// proposes at the cursor of view v
+func completionItems(v *view) (items []string, err error) {
+	c, err := lspClientFor(v.buf)
+	if err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	if err := c.call("textDocument/completion", documentPosition(v), &raw); err != nil {
+		return nil, err
+	}
+	// the result is a list of items or a completion list
+	var list struct {
+		Items []struct {
+			Label      string       `json:"label"`
+			InsertText string       `json:"insertText"`
+			TextEdit   *lspTextEdit `json:"textEdit"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &list.Items); err != nil {
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+	}
+	for _, it := range list.Items {
+		s := it.Label
+		switch {
+		case it.TextEdit != nil:
+			s = it.TextEdit.NewText
+		case it.InsertText != "":
+			s = it.InsertText
+		}
+		// only the first line of the items is inserted
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			s = s[:i]
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+// references lists the references to the identifier under the cursor of
+// view v
+func references(v *view, args []string) (msg string) {
+	c, err := lspClientFor(v.buf)
+	if err != nil {
+		return err.Error()
+	}
+	params := struct {
+		lspDocumentPosition
+		Context struct {
+			IncludeDeclaration bool `json:"includeDeclaration"`
+		} `json:"context"`
+	}{lspDocumentPosition: documentPosition(v)}
+	params.Context.IncludeDeclaration = true
+	var locs []lspLocation
+	if err := c.call("textDocument/references", params, &locs); err != nil {
+		return err.Error()
+	}
+	if len(locs) == 0 {
+		return "No references found"
+	}
+	refs := make([]string, len(locs))
+	for i, l := range locs {
+		fp, _ := uriFile(l.URI)
+		refs[i] = fmt.Sprintf("%v:%v:%v", filepath.Base(fp), l.Range.Start.Line+1,
+			l.Range.Start.Character+1)
+	}
+	return fmt.Sprintf("%v references: %v", len(locs), strings.Join(refs, " "))
+}
+
+// rename renames the identifier under the cursor of view v in all the files
+// where it is used; the files are changed in their buffers, not saved
+func rename(v *view, args []string) (msg string) {
+	if len(args) != 1 {
+		return "Usage: rename newName"
+	}
+	c, err := lspClientFor(v.buf)
+	if err != nil {
+		return err.Error()
+	}
+	params := struct {
+		lspDocumentPosition
+		NewName string `json:"newName"`
+	}{documentPosition(v), args[0]}
+	var result struct {
+		Changes         map[string][]lspTextEdit `json:"changes"`
+		DocumentChanges []struct {
+			TextDocument lspDocumentID `json:"textDocument"`
+			Edits        []lspTextEdit `json:"edits"`
+		} `json:"documentChanges"`
+	}
+	if err := c.call("textDocument/rename", params, &result); err != nil {
+		return err.Error()
+	}
+	edits := result.Changes
+	if edits == nil {
+		edits = map[string][]lspTextEdit{}
+	}
+	for _, dc := range result.DocumentChanges {
+		edits[dc.TextDocument.URI] = append(edits[dc.TextDocument.URI], dc.Edits...)
+	}
+	count := 0
+	for uri, ee := range edits {
+		fp, err := uriFile(uri)
+		if err != nil {
+			return err.Error()
+		}
+		b, err := be.bufferFor(fp)
+		if err != nil {
+			return err.Error()
+		}
+		cs := &mark{0, 0, b}
+		if b == v.buf {
+			cs = v.cs
+		}
+		b.applyEdits(ee, cs)
+		if err := c.sync(b); err != nil {
+			return err.Error()
+		}
+		count += len(ee)
+	}
+	return fmt.Sprintf("%v occurrences renamed in %v files", count, len(edits))
+}
+
+// applyEdits applies the text edits from the server to buffer b as a single
+// change, moving the cursor cs with its line
+func (b *buffer) applyEdits(edits []lspTextEdit, cs *mark) {
+	type edit struct {
+		start, end mark
+		text       string
+	}
+	ee := make([]edit, len(edits))
+	for i, e := range edits {
+		ee[i] = edit{markAt(b, e.Range.Start), markAt(b, e.Range.End), e.NewText}
+	}
+	// from the last, so that the edits do not move the ones still to apply
+	sort.Slice(ee, func(i, j int) bool { return ee[j].start.isBefore(ee[i].start) })
+	lines := append([]line(nil), b.text.all()...)
+	for _, e := range ee {
+		s := string(lines[e.start.line][:e.start.pos]) + e.text +
+			string(lines[e.end.line][e.end.pos:])
+		repl := ropeFromBytes([]byte(s)).all()
+		lines = append(lines[:e.start.line], append(repl, lines[e.end.line+1:]...)...)
+	}
+	b.replaceText(lines, cs)
+}
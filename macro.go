@@ -28,7 +28,7 @@ func recordMacro(ctx *cmdContext) {
 		// save the macro keys removing the last key which is end record key
 		keys := r.macros.keys[:len(r.macros.keys)-1]
 		debug.Printf("macro:\n%v\n", keypressesToEmitString(keys))
-		debug.Printf("buffer:\n%v\n", textToString(ctx.point.buf.text))
+		debug.Printf("buffer:\n%v\n", textToString(ctx.point.buf.text.all()))
 		r.macros.macros[0] = keys
 		r.macros.stop()
 		ctx.msg = "finished recording"
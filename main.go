@@ -20,6 +20,10 @@ var debug *debugLogger
 type register struct {
 	macros   *macroRegister   // recorded macros
 	commands *commandRegister // commands sent in commandMode
+	texts    *textRegisters   // yanked and deleted text
+	changes  *changeRecorder  // the last change, to repeat it
+	searches *searchRegister  // searches history and last search
+	quickfix *quickfixList    // the locations found by :make
 }
 
 // check panics if passed an error
@@ -57,7 +61,11 @@ func init() {
 func initRegisters() register {
 	r := register{}
 	r.macros = &macroRegister{&keyLogger{}, [10][]Keypress{}}
-	r.commands = &commandRegister{make([]line, 0, 10), -1, line{}}
+	r.commands = &commandRegister{make([]line, 0, 10), -1, line{}, commandModePrompt}
+	r.texts = newTextRegisters()
+	r.changes = &changeRecorder{}
+	r.searches = newSearchRegister()
+	r.quickfix = newQuickfixList()
 	return r
 }
 
@@ -76,6 +84,7 @@ func main() {
 	check(err)
 	ui.Draw()
 	defer ui.Close()
+	defer stopLSPClients()
 
 	//activate channels for keypresses and recognized commands
 	keys := make(chan UIEvent, 100)
@@ -105,4 +114,5 @@ func main() {
 
 	// wait for exit signal
 	<-exit
+	waitAsync()
 }
@@ -19,7 +19,7 @@ func (m *mark) atLastLine() bool {
 }
 
 func (m *mark) lastLine() int {
-	return len(m.buf.text) - 1
+	return m.buf.text.len() - 1
 }
 
 func (m *mark) atLineStart() bool {
@@ -28,7 +28,7 @@ func (m *mark) atLineStart() bool {
 
 // atLineEnd returns whether the mark is at line end, that is left of newline char
 func (m *mark) atLineEnd() bool {
-	return m.pos == len(m.buf.text[m.line])-1
+	return m.pos == len(m.buf.text.line(m.line))-1
 }
 
 func (m *mark) atStartOfText() bool {
@@ -48,7 +48,7 @@ func (m *mark) atLastTextChar() bool {
 // lastCharPos return the position of the last char in the line before the newline
 // If the line is empty it returns -1
 func (m *mark) lastCharPos() int {
-	return len(m.buf.text[m.line]) - 2
+	return len(m.buf.text.line(m.line)) - 2
 }
 
 // maxCursPos returns the maximum position the cursor might be on, which is left of
@@ -64,7 +64,7 @@ func (m *mark) maxCursPos() int {
 
 // lineEndPos return the position of the newline char at the end of the line
 func (m mark) lineEndPos() int {
-	return len(m.buf.text[m.line]) - 1
+	return len(m.buf.text.line(m.line)) - 1
 }
 
 func (m *mark) atEmptyLine() bool {
@@ -87,7 +87,7 @@ func (m *mark) fixPos() {
 //fixLine checks that the cursor is on a valid line and puts the cursor
 // on either the first or last line otherwise
 func (m *mark) fixLine() {
-	max := len(m.buf.text) - 1
+	max := m.buf.text.len() - 1
 	if m.line > max {
 		m.line = max
 		return
@@ -164,21 +164,21 @@ func (m *mark) hide() {
 }
 
 func (m *mark) char() rune {
-	return m.buf.text[m.line][m.pos]
+	return m.buf.text.line(m.line)[m.pos]
 }
 
 func (m *mark) prevChar() rune {
 	if m.atLineStart() {
 		return 0
 	}
-	return m.buf.text[m.line][m.pos-1]
+	return m.buf.text.line(m.line)[m.pos-1]
 }
 
 func (m *mark) nextChar() rune {
 	if m.atLineEnd() {
 		return 0
 	}
-	return m.buf.text[m.line][m.pos+1]
+	return m.buf.text.line(m.line)[m.pos+1]
 }
 
 // orderMarks takes two marks (assumed in same buffer) and returns the two marks
@@ -203,12 +203,12 @@ func (m *mark) deltaChars(m2 mark) (delta int) {
 	t := m.buf.text
 	fr, to := orderMarks(*m, m2)
 	if fr.line == to.line {
-		delta = len(t[fr.line][fr.pos:to.pos])
+		delta = len(t.line(fr.line)[fr.pos:to.pos])
 	} else {
-		delta = len(t[fr.line][fr.pos:]) + len(t[to.line][:to.pos])
-		for fr.line++; fr.line < to.line; fr.line++ {
-			delta += len(t[fr.line])
-		}
+		delta = len(t.line(fr.line)[fr.pos:]) + len(t.line(to.line)[:to.pos])
+		t.each(fr.line+1, to.line, func(_ int, ln line) {
+			delta += len(ln)
+		})
 	}
 	if fr.line == m2.line && fr.pos == m2.pos {
 		delta *= -1
@@ -217,7 +217,7 @@ func (m *mark) deltaChars(m2 mark) (delta int) {
 }
 
 func (m *mark) maxLine() int {
-	return len(m.buf.text) - 1
+	return m.buf.text.len() - 1
 }
 
 func (m mark) lastTextCharPos() mark {
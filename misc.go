@@ -24,6 +24,12 @@ func (t *text) appendChars(cs line) {
 	(*t)[len(*t)-1] = append((*t)[len(*t)-1], cs...)
 }
 
+func (t *text) appendText(t2 text) {
+	for _, ln := range t2 {
+		t.appendChars(ln)
+	}
+}
+
 func (t *text) prependChar(ch rune) {
 	if ch == '\n' {
 		*t = append(text{line{}}, *t...)
@@ -37,9 +43,9 @@ func (t text) empty() bool {
 }
 
 // lastChar returns the last rune in text or 0 if the text is empty (that is it has
-// no lines or one empty line
+// no lines or one empty line) or its last line is empty
 func (t text) lastChar() rune {
-	if t.empty() {
+	if t.empty() || len(t[len(t)-1]) == 0 {
 		return 0
 	}
 	return t[len(t)-1][len(t[len(t)-1])-1]
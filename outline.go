@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	commandModeFuncs["outline"] = outline
+	commandModeFuncs["sym"] = jumpToSymbol
+}
+
+// goSymbol is a top level declaration of a Go file
+type goSymbol struct {
+	kind string // func, method, type, const or var
+	recv string // the receiver type of methods
+	name string
+	pos  token.Position
+}
+
+func (s goSymbol) String() string {
+	if s.recv != "" {
+		return fmt.Sprintf("%v %v.%v", s.kind, s.recv, s.name)
+	}
+	return s.kind + " " + s.name
+}
+
+// fileSymbols returns the top level declarations of f, in order
+func fileSymbols(fset *token.FileSet, f *ast.File) (syms []goSymbol) {
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			s := goSymbol{"func", "", d.Name.Name, fset.Position(d.Name.Pos())}
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				s.kind, s.recv = "method", recvTypeName(d.Recv.List[0].Type)
+			}
+			syms = append(syms, s)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					syms = append(syms, goSymbol{"type", "", spec.Name.Name,
+						fset.Position(spec.Name.Pos())})
+				case *ast.ValueSpec:
+					for _, n := range spec.Names {
+						syms = append(syms, goSymbol{d.Tok.String(), "", n.Name,
+							fset.Position(n.Pos())})
+					}
+				}
+			}
+		}
+	}
+	return syms
+}
+
+// recvTypeName returns the name of the type of a receiver, without pointer
+// and type parameters
+func recvTypeName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.StarExpr:
+		return recvTypeName(e.X)
+	case *ast.ParenExpr:
+		return recvTypeName(e.X)
+	case *ast.IndexExpr:
+		return recvTypeName(e.X)
+	case *ast.IndexListExpr:
+		return recvTypeName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return "?"
+}
+
+// outlineList is the buffer listing the symbols of a Go buffer, with the
+// view to move to them
+type outlineList struct {
+	buf    *buffer
+	origin *view
+	marks  map[int]mark // the symbol of each line of the list
+}
+
+var outlines = &outlineList{}
+
+// outline lists the funcs, the methods by receiver, the types, the consts
+// and the vars of the Go buffer of v in a new pane below it; Enter on one
+// moves the cursor of v to it
+func outline(v *view, args []string) (msg string) {
+	g := parseGoBuffer(v.buf)
+	if g == nil {
+		return "Not a Go buffer"
+	}
+	syms := fileSymbols(g.fset, g.file)
+	// the groups in order, methods after the funcs by receiver in the order
+	// of their first method
+	groups := map[string][]goSymbol{}
+	var methodGroups []string
+	width := 0
+	for _, s := range syms {
+		key := s.kind
+		if s.kind == "method" {
+			key = "methods of " + s.recv
+			if groups[key] == nil {
+				methodGroups = append(methodGroups, key)
+			}
+		}
+		groups[key] = append(groups[key], s)
+		if len(s.name) > width {
+			width = len(s.name)
+		}
+	}
+	order := append(append([]string{"func"}, methodGroups...), "type", "const", "var")
+	headers := map[string]string{"func": "funcs", "type": "types", "const": "consts",
+		"var": "vars"}
+
+	var lines []line
+	marks := make(map[int]mark)
+	for _, key := range order {
+		if len(groups[key]) == 0 {
+			continue
+		}
+		header := headers[key]
+		if header == "" {
+			header = key
+		}
+		lines = append(lines, stringToLine(header+"\n"))
+		for _, s := range groups[key] {
+			marks[len(lines)] = markAtByte(v.buf, s.pos.Line-1, s.pos.Column-1)
+			lines = append(lines, stringToLine(fmt.Sprintf("    %-*s %v\n", width, s.name, s.pos.Line)))
+		}
+	}
+	if len(lines) == 0 {
+		return "No symbols"
+	}
+
+	o := outlines
+	if o.buf == nil {
+		o.buf = newListBuffer("[Outline]", outlineJump)
+	}
+	o.buf.text = newRope(lines)
+	o.origin, o.marks = v, marks
+	ui.SplitHorizontal()
+	if cv := ui.CurrentView(); cv != nil && cv != v {
+		cv.moveTo(mark{1, 0, o.buf})
+		cv.cs.fixLineAndPos()
+	}
+	return fmt.Sprintf("%v symbols", len(syms))
+}
+
+// outlineJump moves the cursor of the view of the outline to the symbol
+// under the cursor and goes to its pane
+func outlineJump(ctx *cmdContext) {
+	o := outlines
+	m, found := o.marks[ctx.point.line]
+	if !found {
+		return
+	}
+	if o.origin.buf != m.buf {
+		ctx.msg = "The outlined buffer is no longer shown"
+		return
+	}
+	o.origin.moveTo(m)
+	o.origin.cs.fixLineAndPos()
+	ui.ToPane(up)
+}
+
+// moduleGoFiles returns the Go files of the module of dir, without the
+// ones in testdata, vendor and hidden directories
+func moduleGoFiles(dir string) (files []string) {
+	root := moduleRoot(dir)
+	filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if fp != root && (name == "testdata" || name == "vendor" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") {
+			files = append(files, fp)
+		}
+		return nil
+	})
+	return files
+}
+
+// moduleSymbols returns the top level declarations of the Go files of the
+// module of dir, from the text of their buffers if they are open
+func moduleSymbols(dir string) (syms []goSymbol) {
+	for _, fp := range moduleGoFiles(dir) {
+		if b := be.findBuffer(fp); b != nil {
+			if g := parseGoBuffer(b); g != nil {
+				syms = append(syms, fileSymbols(g.fset, g.file)...)
+			}
+			continue
+		}
+		fset := token.NewFileSet()
+		if f, _ := parser.ParseFile(fset, fp, nil, 0); f != nil {
+			syms = append(syms, fileSymbols(fset, f)...)
+		}
+	}
+	return syms
+}
+
+// fuzzyScore returns whether the chars of pattern appear in order in s,
+// ignoring case, and a score lower for better matches: with the chars
+// together, at the start of s or of its words, in a shorter s
+func fuzzyScore(pattern, s string) (score int, ok bool) {
+	p := []rune(strings.ToLower(pattern))
+	rs := []rune(s)
+	i, last := 0, -1
+	for j, r := range rs {
+		if i == len(p) {
+			break
+		}
+		if unicode.ToLower(r) != p[i] {
+			continue
+		}
+		switch {
+		case last >= 0 && j == last+1:
+		case j == 0 || rs[j-1] == '.' || rs[j-1] == '_' ||
+			(unicode.IsUpper(r) && !unicode.IsUpper(rs[j-1])):
+			score += 1
+		default:
+			score += 3
+		}
+		last = j
+		i++
+	}
+	if i < len(p) {
+		return 0, false
+	}
+	return score*100 + len(rs), true
+}
+
+// jumpToSymbol jumps to the best match of the fuzzy pattern in args among
+// the declarations of the module of v, with all the matches in the quickfix
+// list from the best one
+func jumpToSymbol(v *view, args []string) (msg string) {
+	if len(args) == 0 {
+		return "Usage: sym pattern"
+	}
+	pattern := strings.Join(args, "")
+	type match struct {
+		sym   goSymbol
+		score int
+	}
+	var matches []match
+	for _, s := range moduleSymbols(filepath.Dir(v.buf.filename)) {
+		name := s.name
+		if s.recv != "" {
+			name = s.recv + "." + s.name
+		}
+		if score, ok := fuzzyScore(pattern, name); ok {
+			matches = append(matches, match{s, score})
+		}
+	}
+	if len(matches) == 0 {
+		return "No symbol matching " + pattern
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score < matches[j].score
+	})
+	entries := make([]quickfixEntry, len(matches))
+	for i, m := range matches {
+		entries[i] = quickfixEntry{m.sym.pos.Filename, m.sym.pos.Line, m.sym.pos.Column,
+			m.sym.String()}
+	}
+	r.quickfix.set(entries)
+	return quickfixNext(v, nil)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	commandModeFuncs["make"] = make_
+	commandModeFuncs["makeprg"] = setMakeProgram
+	commandModeFuncs["cn"] = quickfixNext
+	commandModeFuncs["cnext"] = quickfixNext
+	commandModeFuncs["cp"] = quickfixPrev
+	commandModeFuncs["cprevious"] = quickfixPrev
+	commandModeFuncs["copen"] = quickfixOpen
+	cmdKeyNormalMode[KeyEnter] = command{listJump, nil}
+}
+
+// listJumps are the functions jumping with Enter to the location under the
+// cursor in the buffers listing locations, such as the quickfix list
+var listJumps = map[*buffer]cmdFunc{}
+
+// newListBuffer returns a new scratch buffer named name, in normalMode, where
+// Enter calls jump
+func newListBuffer(name string, jump cmdFunc) *buffer {
+	b := be.newBuffer(name)
+	b.mod, b.scratch = normalMode, true
+	listJumps[b] = jump
+	return b
+}
+
+func listJump(ctx *cmdContext) {
+	if f := listJumps[ctx.view.buf]; f != nil {
+		f(ctx)
+	}
+}
+
+// makeProgram is the command run by :make, set with :makeprg
+var makeProgram = "go build ./..."
+
+// quickfixEntry is a location in a file with a message, as printed by
+// compilers and linters
+type quickfixEntry struct {
+	file      string // absolute path
+	line, col int    // from 1, col counting bytes, 0 if unknown
+	msg       string
+}
+
+func (e quickfixEntry) String() string {
+	fp := e.file
+	if rel, err := filepath.Rel(filename("."), fp); err == nil &&
+		!strings.HasPrefix(rel, "..") {
+		fp = rel
+	}
+	if e.col == 0 {
+		return fmt.Sprintf("%v:%v: %v", fp, e.line, e.msg)
+	}
+	return fmt.Sprintf("%v:%v:%v: %v", fp, e.line, e.col, e.msg)
+}
+
+// quickfixList is the list of locations filled by :make; it is filled
+// asynchronously
+type quickfixList struct {
+	mu      sync.Mutex
+	entries []quickfixEntry
+	current int     // the index of the current entry, -1 before the first
+	buf     *buffer // the buffer showing the list with :copen, if any
+}
+
+func newQuickfixList() *quickfixList {
+	return &quickfixList{current: -1}
+}
+
+// fileLineRE matches the lines such as file:line:col: message
+var fileLineRE = regexp.MustCompile(`^([^\s:][^:]*):(\d+)(?::(\d+))?: ?(.*)$`)
+
+// parseFileLines returns the entries for the lines of out of the form
+// file:line:col: message, or file:line: message; relative paths are relative
+// to dir
+func parseFileLines(dir string, out []byte) (entries []quickfixEntry) {
+	for _, ln := range bytes.Split(out, []byte("\n")) {
+		m := fileLineRE.FindSubmatch(bytes.TrimRight(ln, "\r"))
+		if m == nil {
+			continue
+		}
+		fp := string(m[1])
+		if !filepath.IsAbs(fp) {
+			fp = filepath.Join(dir, fp)
+		}
+		l, _ := strconv.Atoi(string(m[2]))
+		col, _ := strconv.Atoi(string(m[3]))
+		entries = append(entries, quickfixEntry{fp, l, col, string(m[4])})
+	}
+	return entries
+}
+
+// set replaces the entries of the list
+func (q *quickfixList) set(entries []quickfixEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries, q.current = entries, -1
+	if q.buf != nil {
+		q.fillBuffer()
+	}
+}
+
+// fillBuffer writes the entries in the list buffer, with the cursor line on
+// the current one
+func (q *quickfixList) fillBuffer() {
+	lines := make([]line, len(q.entries))
+	for i, e := range q.entries {
+		lines[i] = stringToLine(e.String() + "\n")
+	}
+	q.buf.text = newRope(lines)
+}
+
+// len returns the number of entries
+func (q *quickfixList) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// move moves the current entry by n and returns it with its index
+func (q *quickfixList) move(n int) (e quickfixEntry, i int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch i = q.current + n; {
+	case len(q.entries) == 0:
+		return e, i, fmt.Errorf("Quickfix list is empty")
+	case q.current < 0 && n < 0:
+		return e, i, fmt.Errorf("No previous item")
+	case q.current < 0:
+		i = 0
+	case i < 0:
+		return e, i, fmt.Errorf("No previous item")
+	case i >= len(q.entries):
+		return e, i, fmt.Errorf("No more items")
+	}
+	q.current = i
+	return q.entries[i], i, nil
+}
+
+// make_ runs makeProgram with the args in the background, filling the
+// quickfix list with its output
+func make_(v *view, args []string) (msg string) {
+	cmdLine := append(strings.Fields(makeProgram), args...)
+	if len(cmdLine) == 0 {
+		return "No make program"
+	}
+	cmd := exec.Command(cmdLine[0], cmdLine[1:]...)
+	dir := filename(".")
+	wait <- struct{}{}
+	go func() {
+		defer func() { <-wait }()
+		out, err := cmd.CombinedOutput()
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			<-postCmd(func(ctx *cmdContext) { ctx.msg = "make: " + err.Error() })
+			return
+		}
+		entries := parseFileLines(dir, out)
+		// the list buffer may be shown, it is filled by a command
+		<-postCmd(func(ctx *cmdContext) {
+			r.quickfix.set(entries)
+			ctx.msg = fmt.Sprintf("make: %v entries", len(entries))
+		})
+	}()
+	return "make: " + strings.Join(cmdLine, " ")
+}
+
+// waitAsync waits for the operations running in the background: wait can
+// only be filled once each of them took its value back
+func waitAsync() {
+	for i := 0; i < cap(wait); i++ {
+		wait <- struct{}{}
+	}
+	for i := 0; i < cap(wait); i++ {
+		<-wait
+	}
+}
+
+// setMakeProgram sets the command run by :make, or shows it without args
+func setMakeProgram(v *view, args []string) (msg string) {
+	if len(args) == 0 {
+		return "makeprg: " + makeProgram
+	}
+	makeProgram = strings.Join(args, " ")
+	return ""
+}
+
+func quickfixNext(v *view, args []string) (msg string) {
+	return quickfixMove(v, 1)
+}
+
+func quickfixPrev(v *view, args []string) (msg string) {
+	return quickfixMove(v, -1)
+}
+
+// quickfixMove moves the current entry of the quickfix list by n and jumps
+// to it in view v
+func quickfixMove(v *view, n int) string {
+	e, i, err := r.quickfix.move(n)
+	if err != nil {
+		return err.Error()
+	}
+	return quickfixShow(v, e, i, r.quickfix.len())
+}
+
+// quickfixShow shows entry e, the i-th of n, in view v, opening its file if
+// needed
+func quickfixShow(v *view, e quickfixEntry, i, n int) string {
+	b, err := be.bufferFor(e.file)
+	if err != nil {
+		return err.Error()
+	}
+	v.moveTo(markAtByte(b, e.line-1, e.col-1))
+	return fmt.Sprintf("(%v of %v) %v", i+1, n, e.msg)
+}
+
+// quickfixOpen shows the quickfix list in a new pane below the current one;
+// Enter on an entry jumps to it in the pane above
+func quickfixOpen(v *view, args []string) (msg string) {
+	q := r.quickfix
+	q.mu.Lock()
+	if q.buf == nil {
+		q.buf = newListBuffer("[Quickfix]", quickfixJump)
+	}
+	q.fillBuffer()
+	cur := q.current
+	q.mu.Unlock()
+	if cur < 0 {
+		cur = 0
+	}
+	ui.SplitHorizontal()
+	if cv := ui.CurrentView(); cv != nil {
+		v = cv
+	}
+	v.moveTo(mark{cur, 0, q.buf})
+	v.cs.fixLineAndPos()
+	return ""
+}
+
+// quickfixJump jumps to the entry under the cursor in the quickfix list
+func quickfixJump(ctx *cmdContext) {
+	q := r.quickfix
+	q.mu.Lock()
+	i := ctx.point.line
+	if i >= len(q.entries) {
+		q.mu.Unlock()
+		return
+	}
+	q.current = i
+	e, n := q.entries[i], len(q.entries)
+	q.mu.Unlock()
+	v := ctx.view
+	ui.ToPane(up)
+	if cv := ui.CurrentView(); cv != nil {
+		v = cv
+	}
+	ctx.msg = quickfixShow(v, e, i, n)
+}
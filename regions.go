@@ -3,6 +3,7 @@ package main
 import (
 	"regexp"
 	"unicode"
+	"unicode/utf8"
 )
 
 type region struct {
@@ -28,12 +29,11 @@ var motions = map[string]regionFunc{
 	"H":  toLineStart,
 	"gg": toFirstLine,
 	"G":  toLastLine,
+	"n":  toNextMatch,
+	"N":  toPrevMatch,
 }
 
-var regionFuncs = map[string]regionFunc{
-//"iw": innerword,
-//"aw": aword,
-}
+var regionFuncs = map[string]regionFunc{}
 
 // we add all motions to RegionFuncs since all motions are regionFuncs but not
 // vicecersa
@@ -137,32 +137,50 @@ func (m *mark) atStartOfWord() bool {
 		(!unicode.IsSpace(c) && (p == 0))
 }
 
-func findRight(m mark, r *regexp.Regexp) mark {
-	text := m.buf.text
-	offset := m.pos + 1
-	for ln := m.line; ln <= m.lastLine(); ln++ {
-		s := string(text[ln][offset:])
-		pos := r.FindStringIndex(s)
-		if pos != nil {
-			return mark{ln, pos[0] + offset, m.buf}
+// findRight returns the mark at the start of the first match of r after the
+// mark m, wrapping around the end of the text, and whether there was a match
+func findRight(m mark, r *regexp.Regexp) (mark, bool) {
+	t := m.buf.text
+	for i := 0; i <= t.len(); i++ {
+		ln := (m.line + i) % t.len()
+		offset := 0
+		if i == 0 {
+			offset = m.pos + 1
+		}
+		for _, pos := range matchPositions(t.line(ln), r) {
+			if pos[0] >= offset && (i < t.len() || pos[0] <= m.pos) {
+				return mark{ln, pos[0], m.buf}, true
+			}
+		}
+	}
+	return m, false
+}
+
+// findLeft returns the mark at the start of the first match of r before the
+// mark m, wrapping around the start of the text, and whether there was a match
+func findLeft(m mark, r *regexp.Regexp) (mark, bool) {
+	t := m.buf.text
+	for i := 0; i <= t.len(); i++ {
+		ln := ((m.line-i)%t.len() + t.len()) % t.len()
+		matches := matchPositions(t.line(ln), r)
+		for j := len(matches) - 1; j >= 0; j-- {
+			pos := matches[j]
+			if (i > 0 || pos[0] < m.pos) && (i < t.len() || pos[0] >= m.pos) {
+				return mark{ln, pos[0], m.buf}, true
+			}
 		}
-		offset = 0
 	}
-	return m.lastTextCharPos()
+	return m, false
 }
 
-func findLeft(m mark, r *regexp.Regexp) mark {
-	text := m.buf.text
-	for ln := m.line; ln >= 0; ln-- {
-		s := string(text[ln])
-		if ln == m.line {
-			s = s[:m.pos]
-		}
-		matches := r.FindAllStringSubmatchIndex(s, -1)
-		if matches != nil {
-			pos := matches[len(matches)-1]
-			return mark{ln, pos[2], m.buf}
-		}
+// matchPositions returns the start and end positions (in chars, not bytes) of
+// all the matches of r in line ln
+func matchPositions(ln line, r *regexp.Regexp) [][2]int {
+	s := string(ln)
+	var pos [][2]int
+	for _, idx := range r.FindAllStringIndex(s, -1) {
+		start := utf8.RuneCountInString(s[:idx[0]])
+		pos = append(pos, [2]int{start, start + utf8.RuneCountInString(s[idx[0]:idx[1]])})
 	}
-	return m.firstTextCharPos()
+	return pos
 }
@@ -0,0 +1,122 @@
+package main
+
+import "unicode"
+
+const (
+	unnamedRegister     = '"'
+	smallDeleteRegister = '-'
+	lastInsertRegister  = '.'
+)
+
+// textRegister holds a piece of text yanked or deleted; linewise text is made
+// of whole lines and it is put below or above the cursor line
+type textRegister struct {
+	text     text
+	linewise bool
+}
+
+// textRegisters holds the registers used by yank, delete and put commands
+type textRegisters struct {
+	unnamed  textRegister     // the last text yanked or deleted
+	named    [26]textRegister // "a to "z
+	numbered [10]textRegister // "0 is the last yank, "1 to "9 the last deletes
+	small    textRegister     // "- the last delete within a line
+}
+
+func newTextRegisters() *textRegisters {
+	return &textRegisters{}
+}
+
+// isRegisterName returns whether ch names a register
+func isRegisterName(ch rune) bool {
+	ch = unicode.ToLower(ch)
+	return (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+		ch == unnamedRegister || ch == smallDeleteRegister || ch == lastInsertRegister
+}
+
+// get returns the content of register name; b is the buffer used for the
+// read-only last insert register. A zero name means the unnamed register
+func (tr *textRegisters) get(name rune, b *buffer) textRegister {
+	switch {
+	case name == 0 || name == unnamedRegister:
+		return tr.unnamed
+	case name == lastInsertRegister:
+		return textRegister{b.lastInsert.newText, false}
+	case name == smallDeleteRegister:
+		return tr.small
+	case name >= '0' && name <= '9':
+		return tr.numbered[name-'0']
+	case name >= 'a' && name <= 'z', name >= 'A' && name <= 'Z':
+		return tr.named[unicode.ToLower(name)-'a']
+	}
+	return textRegister{}
+}
+
+// set stores t in register name, if it is a writable one, and in the unnamed
+// register. Uppercase names append to the lowercase register
+func (tr *textRegisters) set(name rune, t text, linewise bool) {
+	reg := textRegister{copyText(t), linewise}
+	switch {
+	case name >= 'a' && name <= 'z':
+		tr.named[name-'a'] = reg
+	case name >= 'A' && name <= 'Z':
+		named := &tr.named[name-'A']
+		named.appendText(reg)
+		reg = *named
+	case name == smallDeleteRegister:
+		tr.small = reg
+	}
+	tr.unnamed = reg
+}
+
+// yank stores the yanked text t in register name and in register "0
+func (tr *textRegisters) yank(name rune, t text, linewise bool) {
+	tr.set(name, t, linewise)
+	if name == 0 || name == unnamedRegister {
+		tr.numbered[0] = tr.unnamed
+	}
+}
+
+// delete stores the deleted text t in register name and in the delete
+// registers: "1 (shifting the older deletes up to "9) if the text spans
+// more lines, "- otherwise
+func (tr *textRegisters) delete(name rune, t text, linewise bool) {
+	if t.empty() {
+		return
+	}
+	tr.set(name, t, linewise)
+	if linewise || len(t) > 1 {
+		copy(tr.numbered[2:], tr.numbered[1:9])
+		tr.numbered[1] = tr.unnamed
+	} else if name == 0 || name == unnamedRegister {
+		tr.small = tr.unnamed
+	}
+}
+
+// appendText appends the text in r2 to the register r; the result is linewise
+// if either register is
+func (r *textRegister) appendText(r2 textRegister) {
+	switch {
+	case r.text.empty():
+		*r = r2
+		return
+	case !r.linewise && r2.linewise:
+		r.text.appendChar('\n')
+		r.linewise = true
+	}
+	for _, ln := range r2.text {
+		r.text.appendChars(ln)
+	}
+	if r.linewise && !r2.linewise {
+		r.text.appendChar('\n')
+	}
+}
+
+// copyText returns a copy of text t not sharing memory with it
+func copyText(t text) text {
+	t2 := make(text, len(t))
+	for i, ln := range t {
+		t2[i] = append(line{}, ln...)
+	}
+	return t2
+}
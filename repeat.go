@@ -0,0 +1,78 @@
+package main
+
+// changeRecorder records the commands making up the last change to a buffer
+// so that it can be repeated with '.'. A change is a normal mode command that
+// adds to the buffer changeList, or one that enters insertMode together with
+// the commands changing the text until insertMode is exited
+type changeRecorder struct {
+	cmds      []cmdContext // the commands making up the last change
+	recording bool         // true while recording an insertMode session
+	replaying bool         // true while repeating the last change
+}
+
+// cmdState is the state of a buffer before a command is executed, to find out
+// whether the command changed the buffer
+type cmdState struct {
+	buf     *buffer
+	mod     mode
+	seq     int
+	text    *rope
+	version int
+}
+
+func (cr *changeRecorder) before(ctx *cmdContext) cmdState {
+	if ctx.point == nil {
+		return cmdState{}
+	}
+	b := ctx.point.buf
+	return cmdState{b, b.mod, b.changeList.seq, b.text, b.text.version}
+}
+
+// after records the command in ctx if it is part of a change
+func (cr *changeRecorder) after(ctx *cmdContext, st cmdState) {
+	if cr.replaying {
+		cr.replaying = false
+		return
+	}
+	b := st.buf
+	if b == nil {
+		return
+	}
+	c := *ctx
+	c.point, c.view = nil, nil
+	switch {
+	case cr.recording:
+		if b.text != st.text || b.text.version != st.version {
+			cr.cmds = append(cr.cmds, c)
+		}
+		cr.recording = b.mod == insertMode
+	case st.mod == normalMode && (b.changeList.seq != st.seq || b.mod == insertMode):
+		cr.cmds = []cmdContext{c}
+		cr.recording = b.mod == insertMode
+	}
+}
+
+// repeatLastChange repeats the last change at the cursor; a count greater
+// than one replaces the count of the original command
+func repeatLastChange(ctx *cmdContext) {
+	cr := r.changes
+	if len(cr.cmds) == 0 {
+		ctx.msg = "No change to repeat"
+		return
+	}
+	cr.replaying = true
+	for i, c := range cr.cmds {
+		c.point, c.view, c.silent = ctx.point, ctx.view, true
+		if i == 0 && ctx.num > 1 {
+			c.num = ctx.num
+		}
+		c.cmd(&c)
+		if i == 0 && ctx.num > 1 {
+			cr.cmds[0].num = ctx.num
+		}
+	}
+	if ctx.point.buf.mod == insertMode {
+		toNormalMode(ctx)
+	}
+	ctx.msg = ""
+}
@@ -0,0 +1,340 @@
+package main
+
+import "bytes"
+
+const (
+	ropeMaxLeaf = 512 // max number of lines in a leaf before it is split
+	ropeMinLeaf = 128 // leaves smaller than this are merged when rebalancing
+)
+
+// rope holds the lines of a buffer in a tree whose leaves are chunks of lines,
+// so that inserting or deleting lines only touches one leaf and the path to it
+// instead of copying the whole text. Lines are addressed by their index, the
+// same way marks address them
+type rope struct {
+	root    *ropeNode
+	version int // incremented at each change to the lines
+}
+
+// ropeNode is either a leaf holding lines or an internal node with two children
+type ropeNode struct {
+	count  int       // number of lines in the subtree
+	depth  int       // 0 for leaves
+	left   *ropeNode // nil for leaves
+	right  *ropeNode // nil for leaves
+	lines  []line    // the lines of a leaf, nil until raw is decoded
+	raw    [][]byte  // undecoded lines of a leaf loaded from file, or nil
+	leaves int       // number of leaves in the subtree
+}
+
+// newRope returns a rope holding the passed in lines; if there are no lines
+// the rope holds one empty line
+func newRope(lines []line) *rope {
+	if len(lines) == 0 {
+		lines = []line{newLine()}
+	}
+	var leaves []*ropeNode
+	for len(lines) > 0 {
+		n := ropeMaxLeaf / 2
+		if n > len(lines) {
+			n = len(lines)
+		}
+		leaves = append(leaves, newLeaf(append([]line(nil), lines[:n]...), nil))
+		lines = lines[n:]
+	}
+	return &rope{root: buildRope(leaves)}
+}
+
+// ropeFromBytes returns a rope holding the text in b; lines are kept as bytes
+// and turned into runes only the first time their leaf is accessed. Every line
+// ends with a newline char, one is added to the last line if missing
+func ropeFromBytes(b []byte) *rope {
+	if len(b) == 0 {
+		return newRope(nil)
+	}
+	var leaves []*ropeNode
+	var raw [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		var ln []byte
+		if i < 0 {
+			ln, b = append(b[:len(b):len(b)], '\n'), nil
+		} else {
+			ln, b = b[:i+1], b[i+1:]
+		}
+		// drop carriage returns of \r\n line endings
+		if k := len(ln) - 2; k >= 0 && ln[k] == '\r' {
+			ln = append(ln[:k:k], '\n')
+		}
+		raw = append(raw, ln)
+		if len(raw) == ropeMaxLeaf/2 {
+			leaves = append(leaves, newLeaf(nil, raw))
+			raw = nil
+		}
+	}
+	if len(raw) > 0 {
+		leaves = append(leaves, newLeaf(nil, raw))
+	}
+	return &rope{root: buildRope(leaves)}
+}
+
+func newLeaf(lines []line, raw [][]byte) *ropeNode {
+	n := &ropeNode{lines: lines, raw: raw, leaves: 1}
+	n.count = len(lines) + len(raw)
+	return n
+}
+
+func newInternal(left, right *ropeNode) *ropeNode {
+	n := &ropeNode{left: left, right: right}
+	n.update()
+	return n
+}
+
+// buildRope builds a balanced tree out of the passed in leaves
+func buildRope(leaves []*ropeNode) *ropeNode {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	mid := len(leaves) / 2
+	return newInternal(buildRope(leaves[:mid]), buildRope(leaves[mid:]))
+}
+
+func (n *ropeNode) isLeaf() bool {
+	return n.left == nil
+}
+
+// update recomputes the node's counters from its children
+func (n *ropeNode) update() {
+	n.count = n.left.count + n.right.count
+	n.leaves = n.left.leaves + n.right.leaves
+	n.depth = n.left.depth
+	if n.right.depth > n.depth {
+		n.depth = n.right.depth
+	}
+	n.depth++
+}
+
+// decode turns the raw bytes of a leaf into lines
+func (n *ropeNode) decode() {
+	if n.raw == nil {
+		return
+	}
+	n.lines = make([]line, len(n.raw))
+	for i, b := range n.raw {
+		n.lines[i] = bytesToLine(b)
+	}
+	n.raw = nil
+}
+
+// leaf returns the leaf containing line i and the index of the line in it
+func (n *ropeNode) leaf(i int) (*ropeNode, int) {
+	for !n.isLeaf() {
+		if i < n.left.count {
+			n = n.left
+		} else {
+			i -= n.left.count
+			n = n.right
+		}
+	}
+	n.decode()
+	return n, i
+}
+
+// len returns the number of lines in the rope
+func (r *rope) len() int {
+	return r.root.count
+}
+
+// line returns line i; the returned line shares memory with the rope so it
+// must not be modified, use setLine to change it
+func (r *rope) line(i int) line {
+	n, j := r.root.leaf(i)
+	return n.lines[j]
+}
+
+// setLine replaces line i with ln
+func (r *rope) setLine(i int, ln line) {
+	n, j := r.root.leaf(i)
+	n.lines[j] = ln
+	r.version++
+}
+
+// lines returns a slice with the lines from index 'from' up to 'to' excluded
+func (r *rope) lines(from, to int) []line {
+	ls := make([]line, 0, to-from)
+	r.each(from, to, func(_ int, ln line) {
+		ls = append(ls, ln)
+	})
+	return ls
+}
+
+// all returns a slice with all the lines in the rope
+func (r *rope) all() []line {
+	return r.lines(0, r.len())
+}
+
+// each calls f on each line from index 'from' up to 'to' excluded
+func (r *rope) each(from, to int, f func(i int, ln line)) {
+	r.root.each(from, to, 0, f)
+}
+
+func (n *ropeNode) each(from, to, offset int, f func(i int, ln line)) {
+	if to <= offset || from >= offset+n.count {
+		return
+	}
+	if !n.isLeaf() {
+		n.left.each(from, to, offset, f)
+		n.right.each(from, to, offset+n.left.count, f)
+		return
+	}
+	n.decode()
+	for j, ln := range n.lines {
+		if i := offset + j; i >= from && i < to {
+			f(i, ln)
+		}
+	}
+}
+
+// insert inserts the lines ls before line at; if at is equal to the number of
+// lines the lines are appended
+func (r *rope) insert(at int, ls ...line) {
+	if len(ls) == 0 {
+		return
+	}
+	r.root = r.root.insert(at, ls)
+	r.version++
+	r.rebalance()
+}
+
+func (n *ropeNode) insert(at int, ls []line) *ropeNode {
+	if !n.isLeaf() {
+		if at <= n.left.count {
+			n.left = n.left.insert(at, ls)
+		} else {
+			n.right = n.right.insert(at-n.left.count, ls)
+		}
+		n.update()
+		return n
+	}
+	n.decode()
+	if len(n.lines)+len(ls) <= ropeMaxLeaf {
+		// leaves own their slice so we can shift lines in place
+		old := len(n.lines)
+		n.lines = append(n.lines, ls...)
+		copy(n.lines[at+len(ls):], n.lines[at:old])
+		copy(n.lines[at:], ls)
+		n.count = len(n.lines)
+		return n
+	}
+	lines := make([]line, 0, len(n.lines)+len(ls))
+	lines = append(lines, n.lines[:at]...)
+	lines = append(lines, ls...)
+	lines = append(lines, n.lines[at:]...)
+	var leaves []*ropeNode
+	for len(lines) > 0 {
+		k := ropeMaxLeaf / 2
+		if k > len(lines) {
+			k = len(lines)
+		}
+		leaves = append(leaves, newLeaf(lines[:k:k], nil))
+		lines = lines[k:]
+	}
+	return buildRope(leaves)
+}
+
+// delete deletes the lines from index 'from' up to 'to' excluded; a rope is
+// never left empty, if all lines are deleted it holds one empty line
+func (r *rope) delete(from, to int) {
+	if from >= to {
+		return
+	}
+	r.root = r.root.delete(from, to)
+	if r.root == nil {
+		r.root = newRope(nil).root
+	}
+	r.version++
+	r.rebalance()
+}
+
+// delete returns the node without the deleted lines, or nil if empty
+func (n *ropeNode) delete(from, to int) *ropeNode {
+	if to <= 0 || from >= n.count {
+		return n
+	}
+	if !n.isLeaf() {
+		lc := n.left.count
+		n.left = n.left.delete(from, to)
+		n.right = n.right.delete(from-lc, to-lc)
+		switch {
+		case n.left == nil:
+			return n.right
+		case n.right == nil:
+			return n.left
+		}
+		n.update()
+		return n
+	}
+	n.decode()
+	if from < 0 {
+		from = 0
+	}
+	if to > n.count {
+		to = n.count
+	}
+	old := len(n.lines)
+	n.lines = append(n.lines[:from], n.lines[to:]...)
+	for i := len(n.lines); i < old; i++ {
+		n.lines[:old][i] = nil
+	}
+	n.count = len(n.lines)
+	if n.count == 0 {
+		return nil
+	}
+	return n
+}
+
+// replace replaces the lines from index 'from' up to 'to' excluded with ls
+func (r *rope) replace(from, to int, ls ...line) {
+	if to-from == len(ls) {
+		for i, ln := range ls {
+			r.setLine(from+i, ln)
+		}
+		return
+	}
+	r.insert(to, ls...)
+	r.delete(from, to)
+}
+
+// rebalance rebuilds the tree when it gets too deep for its number of leaves,
+// merging small leaves on the way; lines are not copied, only leaf slices
+func (r *rope) rebalance() {
+	maxDepth := 4
+	for l := r.root.leaves; l > 1; l /= 2 {
+		maxDepth += 2
+	}
+	if r.root.depth <= maxDepth {
+		return
+	}
+	var leaves []*ropeNode
+	r.root.collectLeaves(func(n *ropeNode) {
+		if last := len(leaves) - 1; last >= 0 &&
+			leaves[last].count < ropeMinLeaf && leaves[last].count+n.count <= ropeMaxLeaf {
+			leaves[last].decode()
+			n.decode()
+			leaves[last].lines = append(leaves[last].lines, n.lines...)
+			leaves[last].count = len(leaves[last].lines)
+			return
+		}
+		leaves = append(leaves, n)
+	})
+	r.root = buildRope(leaves)
+}
+
+func (n *ropeNode) collectLeaves(f func(n *ropeNode)) {
+	if n.isLeaf() {
+		f(n)
+		return
+	}
+	n.left.collectLeaves(f)
+	n.right.collectLeaves(f)
+}
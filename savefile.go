@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// backupFiles tells whether saving a file keeps its previous content in a
+// backup file, named after it with a '~' appended
+var backupFiles = false
+
+// fileWriter returns the writer of the temporary file f a buffer is saved
+// to; the tests replace it to simulate failing writes
+var fileWriter = func(f *os.File) io.Writer { return f }
+
+func init() {
+	commandModeFuncs["backup"] = setBackup(true)
+	commandModeFuncs["nobackup"] = setBackup(false)
+}
+
+// setBackup returns the command turning the backup files on or off
+func setBackup(on bool) commandModeF {
+	return func(v *view, args []string) (msg string) {
+		backupFiles = on
+		if on {
+			return "backup files on"
+		}
+		return "backup files off"
+	}
+}
+
+// saveAs saves the buffer in a file named after the passed parameter. The
+// text is written to a temporary file next to it, which replaces the file
+// once it is complete and synced to disk, so that a failed save leaves the
+// file untouched. The file keeps its mode and owner, and a symbolic link
+// keeps pointing to it
+func (b *buffer) saveAs(filename string) error {
+	target, err := resolveLinks(filename)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(target)
+	switch {
+	case os.IsNotExist(err):
+		fi = nil
+	case err != nil:
+		return err
+	case backupFiles:
+		if err := backupFile(target, fi); err != nil {
+			return fmt.Errorf("cannot back up %v: %v", filename, err)
+		}
+	}
+
+	h := sha256.New()
+	var content bytes.Buffer
+	mtime, err := writeAtomic(target, fi, func(w io.Writer) error {
+		return b.writeText(io.MultiWriter(w, h, &content))
+	})
+	if err != nil {
+		return fmt.Errorf("cannot save %v: %v", filename, err)
+	}
+	if filename == b.filename {
+		b.fileContent, b.fileSync = content.Bytes(), mtime
+		b.setSaved()
+		if err := b.saveUndo(h.Sum(nil)); err != nil {
+			debug.Printf("cannot save the undo file of %v: %v", filename, err)
+		}
+	}
+	return nil
+}
+
+// writeText writes the text of b to w; the error tells the line that could
+// not be written
+func (b *buffer) writeText(w io.Writer) error {
+	lc := &lineCounter{w: w}
+	if _, err := io.Copy(lc, &bufReader{buf: b}); err != nil {
+		return fmt.Errorf("line %v: %v", lc.lines+1, err)
+	}
+	return nil
+}
+
+// lineCounter is a writer counting the complete lines written to w
+type lineCounter struct {
+	w     io.Writer
+	lines int
+}
+
+func (lc *lineCounter) Write(p []byte) (n int, err error) {
+	n, err = lc.w.Write(p)
+	lc.lines += bytes.Count(p[:n], []byte{'\n'})
+	return n, err
+}
+
+// resolveLinks returns the path of the file the symbolic links of fp lead
+// to, fp itself if it does not exist yet
+func resolveLinks(fp string) (string, error) {
+	target, err := filepath.EvalSymlinks(fp)
+	if os.IsNotExist(err) {
+		// a new file, or a link to a file not created yet
+		if dest, err := os.Readlink(fp); err == nil {
+			if !filepath.IsAbs(dest) {
+				dest = filepath.Join(filepath.Dir(fp), dest)
+			}
+			return dest, nil
+		}
+		return fp, nil
+	}
+	return target, err
+}
+
+// writeFile writes data to the file at fp the way buffers are saved: through
+// a temporary file, keeping the mode, the owner and the symbolic links of
+// the file
+func writeFile(fp string, data []byte) error {
+	target, err := resolveLinks(fp)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err = writeAtomic(target, fi, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	return err
+}
+
+// backupFile copies the file at fp, with info fi, to its backup file
+func backupFile(fp string, fi os.FileInfo) error {
+	content, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return err
+	}
+	_, err = writeAtomic(fp+"~", fi, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	return err
+}
+
+// writeAtomic replaces the file at fp with the content written by write,
+// through a temporary file in the same directory renamed to fp. The new
+// file gets the mode and the owner of fi, the info of the file replaced, or
+// the default mode if fi is nil. It returns the modification time of the
+// new file
+func writeAtomic(fp string, fi os.FileInfo, write func(w io.Writer) error) (mtime time.Time, err error) {
+	f, err := ioutil.TempFile(filepath.Dir(fp), "."+filepath.Base(fp)+".")
+	if err != nil {
+		return mtime, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	mode := os.FileMode(0644)
+	if fi != nil {
+		// changing the owner can clear the setuid and setgid bits
+		if err := chownLike(f, fi); err != nil {
+			debug.Printf("cannot keep the owner of %v: %v", fp, err)
+		}
+		mode = fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+	if err = f.Chmod(mode); err != nil {
+		return mtime, err
+	}
+	if err = write(fileWriter(f)); err != nil {
+		return mtime, err
+	}
+	if err = f.Sync(); err != nil {
+		return mtime, err
+	}
+	mtime = fileTime(f)
+	if err = f.Close(); err != nil {
+		return mtime, err
+	}
+	if err = os.Rename(f.Name(), fp); err != nil {
+		return mtime, err
+	}
+	syncDir(filepath.Dir(fp))
+	return mtime, nil
+}
+
+// syncDir syncs the directory dir so that the files renamed in it stay
+// renamed after a crash; not all systems support it, the errors are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
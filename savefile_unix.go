@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// chownLike gives the file f the owner and the group of the file with info
+// fi
+func chownLike(f *os.File, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if fst, err := f.Stat(); err == nil {
+		if own, ok := fst.Sys().(*syscall.Stat_t); ok && own.Uid == st.Uid && own.Gid == st.Gid {
+			return nil
+		}
+	}
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
@@ -0,0 +1,223 @@
+package main
+
+import "regexp"
+
+const (
+	searchForwardPrompt  = "/"
+	searchBackwardPrompt = "?"
+)
+
+var searchModeKeyTable = map[Key]func(){
+	KeyArrowRight: nil,
+	KeyArrowLeft:  nil,
+	KeyArrowDown:  func() { r.searches.next(); incrementalSearch() },
+	KeyArrowUp:    func() { r.searches.previous(); incrementalSearch() },
+	KeyTab:        nil,
+	KeyDelete:     nil,
+	KeySpace:      func() { be.msgLine = append(be.msgLine, ' '); incrementalSearch() },
+	KeyBackspace:  func() { r.searches.backSpace(); incrementalSearch() },
+	KeyBackspace2: func() { r.searches.backSpace(); incrementalSearch() },
+}
+
+// searchRegister holds the search history, the last search and the state of
+// the search being typed
+type searchRegister struct {
+	*commandRegister
+	re        *regexp.Regexp // the last search pattern
+	dir       direction      // the direction of the last search
+	highlight *regexp.Regexp // the pattern whose matches are highlighted
+	// the search being typed
+	cs      *mark       // the cursor of the view where the search started
+	start   mark        // the cursor position when the search started
+	typeDir direction   // the direction of the search being typed
+	pending *cmdContext // the operator waiting for the search as its region
+}
+
+func newSearchRegister() *searchRegister {
+	return &searchRegister{
+		commandRegister: &commandRegister{make([]line, 0, 10), -1, line{},
+			searchForwardPrompt},
+	}
+}
+
+func searchForward(ctx *cmdContext) {
+	ctx.msg = startSearch(ctx.point, right, nil)
+}
+
+func searchBackward(ctx *cmdContext) {
+	ctx.msg = startSearch(ctx.point, left, nil)
+}
+
+// startSearch enters search mode moving cursor cs and returns the prompt; if
+// pending is not nil the search is the region of the pending operator
+func startSearch(cs *mark, dir direction, pending *cmdContext) string {
+	s := r.searches
+	s.cs, s.start, s.typeDir, s.pending = cs, *cs, dir, pending
+	be.searchMode = true
+	if dir == left {
+		return searchBackwardPrompt
+	}
+	return searchForwardPrompt
+}
+
+// incrementalSearch moves the cursor to the first match of the pattern being
+// typed and highlights all its matches
+func incrementalSearch() {
+	s := r.searches
+	re, err := regexp.Compile(string(be.msgLine[len(s.prompt):]))
+	if err != nil || len(be.msgLine) == len(s.prompt) {
+		*s.cs = s.start
+		s.highlight = nil
+		return
+	}
+	s.highlight = re
+	if m, ok := find(s.start, re, s.typeDir); ok {
+		*s.cs = m
+	} else {
+		*s.cs = s.start
+	}
+}
+
+func exitSearchMode() {
+	r.searches.exit()
+	be.searchMode = false
+	ui.Draw()
+}
+
+// enterSearch executes the search typed, or the last search if nothing was
+// typed, and returns the message for the user
+func enterSearch(pattern line) (msg string) {
+	s := r.searches
+	*s.cs = s.start
+	if len(pattern) > 0 {
+		s.add(pattern)
+		re, err := regexp.Compile(string(pattern))
+		if err != nil {
+			s.highlight = s.re
+			return "Invalid pattern: " + err.Error()
+		}
+		s.re = re
+	}
+	if s.re == nil {
+		return "No previous search pattern"
+	}
+	s.dir, s.highlight = s.typeDir, s.re
+	if s.pending != nil {
+		ctx := s.pending
+		s.pending = nil
+		ctx.reg = toNextMatch
+		ctx.argString = "/"
+		pushCmd(ctx)
+		return ""
+	}
+	m, ok := find(s.start, s.re, s.dir)
+	if !ok {
+		return "Pattern not found: " + s.re.String()
+	}
+	*s.cs = m
+	return ""
+}
+
+func parseSearchMode(ev *UIEvent, ctx *cmdContext) (
+	nextParser parseFunc, reprocessEvent bool) {
+	s := r.searches
+	switch {
+	case ev.Type == UIEventTimeout:
+		return parseSearchMode, false
+	case ev.Key.isSpecial:
+		switch ev.Key.Special {
+		case KeyCtrlJ, KeyEnter:
+			if msg := enterSearch(be.msgLine[len(s.prompt):]); msg != "" {
+				be.msgLine = stringToLine(msg)
+			}
+			exitSearchMode()
+			return nil, false
+		case KeyEsc, KeyCtrlC:
+			*s.cs = s.start
+			s.highlight, s.pending = s.re, nil
+			be.msgLine = be.msgLine[:0]
+			exitSearchMode()
+			return nil, false
+		default:
+			if f := searchModeKeyTable[ev.Key.Special]; f != nil {
+				f()
+			}
+		}
+	default:
+		be.msgLine = append(be.msgLine, ev.Key.Char)
+		s.current = append(line{}, be.msgLine[len(s.prompt):]...)
+		incrementalSearch()
+	}
+	ui.Draw()
+	return parseSearchMode, false
+}
+
+// parseSearchRegion starts a search to be used as region by the operator
+// in ctx, e.g. d/foo
+func parseSearchRegion(ev *UIEvent, ctx *cmdContext) (
+	nextParser parseFunc, reprocessEvent bool) {
+	dir := right
+	if ev.Key.Char == '?' {
+		dir = left
+	}
+	pending := *ctx
+	pending.point = ev.View.cs
+	be.msgLine = stringToLine(startSearch(ev.View.cs, dir, &pending))
+	ui.Draw()
+	return nil, false
+}
+
+// find returns the first match of re from m in direction dir
+func find(m mark, re *regexp.Regexp, dir direction) (mark, bool) {
+	if dir == left {
+		return findLeft(m, re)
+	}
+	return findRight(m, re)
+}
+
+// toNextMatch is the motion to the next match of the last search
+func toNextMatch(m mark) (region, direction) {
+	return searchMotion(m, r.searches.dir)
+}
+
+// toPrevMatch is the motion to the next match of the last search in the
+// opposite direction
+func toPrevMatch(m mark) (region, direction) {
+	return searchMotion(m, opposite(r.searches.dir))
+}
+
+func searchMotion(m mark, dir direction) (region, direction) {
+	re := r.searches.re
+	if re == nil {
+		return region{m, m}, dir
+	}
+	m2, _ := find(m, re, dir)
+	if dir == right && m2.isBefore(m) || dir == left && m.isBefore(m2) {
+		// wrapped around
+		dir = opposite(dir)
+	}
+	return region{m, m2}, dir
+}
+
+// opposite returns the opposite of the horizontal direction dir
+func opposite(dir direction) direction {
+	if dir == left {
+		return right
+	}
+	return left
+}
+
+// searchMatches returns the positions of the matches of the highlighted
+// search in line ln
+func searchMatches(ln line) [][2]int {
+	if r.searches.highlight == nil {
+		return nil
+	}
+	return matchPositions(ln, r.searches.highlight)
+}
+
+// noHighlight stops highlighting the matches of the last search
+func noHighlight(v *view, args []string) (msg string) {
+	r.searches.highlight = nil
+	return ""
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// substitution is a :s command being executed on a range of lines
+type substitution struct {
+	v       *view
+	re      *regexp.Regexp
+	repl    string
+	global  bool    // replace all matches in a line, not just the first
+	line    int     // the line where to look for the next match
+	last    int     // the last line of the range
+	src     string  // the text of line before its replacements
+	matches [][]int // the matches in src left, nil if line is not searched yet
+	shift   int     // the change of the length of line by its replacements
+	match   []int   // the match in src waiting for confirmation
+	matched bool    // if true there was at least a match
+	count   int     // the number of replacements done
+	lastSub int     // the line of the last replacement
+	record  func()  // records the substitution in the changeList
+}
+
+// substitute replaces the matches of a regular expression in the lines of
+// range rg; arg is like /pattern/replacement/flags where the replacement can
+// refer to submatches as $1 and flags can be g (all matches in a line), i
+// (ignore case) and c (confirm each replacement). An empty pattern is the
+// last search pattern
+func substitute(v *view, rg lineRange, arg string) (msg string) {
+	pattern, repl, flags, err := splitSubstitute(arg)
+	if err != nil {
+		return err.Error()
+	}
+	if strings.Contains(flags, "i") && pattern != "" {
+		pattern = "(?i)" + pattern
+	}
+	re := r.searches.re
+	if pattern != "" {
+		if re, err = regexp.Compile(pattern); err != nil {
+			return "Invalid pattern: " + err.Error()
+		}
+	}
+	if re == nil {
+		return "No previous search pattern"
+	}
+	for _, f := range flags {
+		if !strings.ContainsRune("gic", f) {
+			return fmt.Sprintf("Invalid flag: %c", f)
+		}
+	}
+	// the pattern becomes the last search pattern
+	r.searches.re, r.searches.dir, r.searches.highlight = re, right, re
+	s := &substitution{
+		v:      v,
+		re:     re,
+		repl:   repl,
+		global: strings.Contains(flags, "g"),
+		line:   rg.from,
+		last:   rg.to,
+		record: v.buf.recordLinesChange(rg.from, rg.to),
+	}
+	if strings.Contains(flags, "c") {
+		return s.ask()
+	}
+	s.replaceAll()
+	return s.done()
+}
+
+// splitSubstitute splits the argument of :s in pattern, replacement and
+// flags; the first char is the delimiter, which can be escaped with a
+// backslash in pattern and replacement
+func splitSubstitute(arg string) (pattern, repl, flags string, err error) {
+	if arg == "" {
+		return "", "", "", fmt.Errorf("Usage: s/pattern/replacement/flags")
+	}
+	delim := arg[0]
+	var parts []string
+	var part []byte
+	for i := 1; i < len(arg); i++ {
+		switch {
+		case arg[i] == '\\' && i+1 < len(arg) && arg[i+1] == delim:
+			part = append(part, delim)
+			i++
+		case arg[i] == delim && len(parts) < 2:
+			parts = append(parts, string(part))
+			part = nil
+		default:
+			part = append(part, arg[i])
+		}
+	}
+	parts = append(parts, string(part))
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// next finds the next match in the range, returning false if there is none.
+// The matches of a line are found at once in its whole text, so that the
+// anchors and the word boundaries see the text around them
+func (s *substitution) next() bool {
+	t := s.v.buf.text
+	for ; s.line <= s.last && s.line < t.len(); s.line, s.matches = s.line+1, nil {
+		if s.matches == nil {
+			ln := string(t.line(s.line))
+			s.src, s.shift = ln[:len(ln)-1], 0
+			n := 1
+			if s.global {
+				n = -1
+			}
+			s.matches = s.re.FindAllStringSubmatchIndex(s.src, n)
+		}
+		if len(s.matches) > 0 {
+			s.match, s.matches = s.matches[0], s.matches[1:]
+			s.matched = true
+			return true
+		}
+	}
+	return false
+}
+
+// replace replaces the current match
+func (s *substitution) replace() {
+	t := s.v.buf.text
+	ln := string(t.line(s.line))
+	ln = ln[:len(ln)-1]
+	repl := string(s.re.ExpandString(nil, s.repl, s.src, s.match))
+	ln = ln[:s.match[0]+s.shift] + repl + ln[s.match[1]+s.shift:]
+	s.shift += len(repl) - (s.match[1] - s.match[0])
+	s.count, s.lastSub = s.count+1, s.line
+	t.setLine(s.line, append(stringToLine(ln), '\n'))
+}
+
+// replaceAll replaces all the remaining matches without asking
+func (s *substitution) replaceAll() {
+	for s.next() {
+		s.replace()
+	}
+}
+
+// ask moves the cursor to the next match and asks the user whether to replace
+// it; if there are no more matches the substitution is done
+func (s *substitution) ask() string {
+	if !s.next() {
+		be.confirm = nil
+		return s.done()
+	}
+	be.confirm = s
+	ln := string(s.v.buf.text.line(s.line))
+	*s.v.cs = mark{s.line, len([]rune(ln[:s.match[0]+s.shift])), s.v.buf}
+	return "replace with " + s.repl + " (y/n/a/q/l)?"
+}
+
+// done records the substitution as a single change and returns the message
+// for the user
+func (s *substitution) done() string {
+	switch {
+	case !s.matched:
+		return "Pattern not found: " + s.re.String()
+	case s.count == 0:
+		return "0 substitutions"
+	}
+	s.record()
+	*s.v.cs = mark{s.lastSub, 0, s.v.buf}
+	_, s.v.cs.pos = lineIndent(s.v.buf, s.lastSub)
+	if s.count == 1 {
+		return "1 substitution"
+	}
+	return fmt.Sprintf("%v substitutions", s.count)
+}
+
+// parseConfirm reads the answer of the user to the confirmation asked by a
+// substitution
+func parseConfirm(ev *UIEvent, ctx *cmdContext) (
+	nextParser parseFunc, reprocessEvent bool) {
+	s := be.confirm
+	if ev.Type == UIEventTimeout {
+		return parseConfirm, false
+	}
+	var msg string
+	switch {
+	case ev.Key.isSpecial && (ev.Key.Special == KeyEsc || ev.Key.Special == KeyCtrlC),
+		ev.Key.Char == 'q':
+		be.confirm = nil
+		msg = s.done()
+	case ev.Key.Char == 'y':
+		s.replace()
+		msg = s.ask()
+	case ev.Key.Char == 'n':
+		msg = s.ask()
+	case ev.Key.Char == 'a':
+		be.confirm = nil
+		s.replace()
+		s.replaceAll()
+		msg = s.done()
+	case ev.Key.Char == 'l':
+		be.confirm = nil
+		s.replace()
+		msg = s.done()
+	default:
+		return parseConfirm, false
+	}
+	be.msgLine = stringToLine(msg)
+	ui.Draw()
+	if be.confirm != nil {
+		return parseConfirm, false
+	}
+	return nil, false
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBufferList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-buffers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var bufs []*buffer
+	for _, name := range []string{"one.txt", "two.txt", "three.txt"} {
+		fp := filepath.Join(dir, name)
+		ioutil.WriteFile(fp, []byte("1\n2\n3\n"), 0644)
+		b, err := be.bufferFor(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufs = append(bufs, b)
+	}
+	defer func() {
+		for _, b := range bufs {
+			be.removeBuffer(b)
+		}
+	}()
+	one, two, three := bufs[0], bufs[1], bufs[2]
+	v := &view{one, &mark{0, 0, one}, 0}
+	e := newKeyPressEmitter(v)
+
+	// each buffer gets its cursor back
+	e.emit("Gx:bn", KeyEnter)
+	if v.buf != two || v.cs.line != 0 {
+		t.Fatalf("expected two.txt:0, got %v:%v", v.buf.name, v.cs.line)
+	}
+	if msg := string(be.msgLine); msg != "two.txt: 3 lines" {
+		t.Errorf("wrong message %q", msg)
+	}
+	e.emit(":bp", KeyEnter)
+	if v.buf != one || v.cs.line != 2 {
+		t.Errorf("expected one.txt:2, got %v:%v", v.buf.name, v.cs.line)
+	}
+	e.emit(fmt.Sprintf(":b %v", three.id), KeyEnter)
+	if v.buf != three {
+		t.Errorf("expected three.txt, got %v", v.buf.name)
+	}
+	e.emit(":b tw", KeyEnter)
+	if v.buf != two {
+		t.Errorf("expected two.txt, got %v", v.buf.name)
+	}
+	e.emit(KeyCtrl6)
+	if v.buf != three {
+		t.Errorf("expected the alternate three.txt, got %v", v.buf.name)
+	}
+	e.emit(":b .txt", KeyEnter)
+	if msg := string(be.msgLine); msg != "More than one match for .txt" {
+		t.Errorf("wrong message %q", msg)
+	}
+
+	e.emit(":ls", KeyEnter)
+	for _, entry := range []string{
+		fmt.Sprintf("%v + \"one.txt\" line 3", one.id),
+		fmt.Sprintf("%v # \"two.txt\" line 1", two.id),
+		fmt.Sprintf("%v %% \"three.txt\" line 1", three.id),
+	} {
+		if !strings.Contains(string(be.msgLine), entry) {
+			t.Errorf("%q not in %q", entry, be.msgLine)
+		}
+	}
+
+	// the view of a deleted buffer shows the alternate one
+	e.emit(":bd", KeyEnter)
+	if v.buf != two || be.findBuffer(three.filename) != nil {
+		t.Errorf("three.txt not deleted, showing %v", v.buf.name)
+	}
+	e.emit(KeyCtrl6)
+	if msg := string(be.msgLine); msg != "No alternate buffer" {
+		t.Errorf("wrong message %q", msg)
+	}
+	e.emit(":bd one", KeyEnter)
+	if msg := string(be.msgLine); msg != unsavedMessage(one) {
+		t.Errorf("wrong message %q", msg)
+	}
+	e.emit(":bd! one", KeyEnter)
+	if be.findBuffer(one.filename) != nil {
+		t.Error("one.txt not deleted")
+	}
+}
+
+func TestModified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-modified")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, c := filepath.Join(dir, "a.txt"), filepath.Join(dir, "c.txt")
+	ioutil.WriteFile(a, []byte("abc\n"), 0644)
+	b, err := be.bufferFor(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &view{b, &mark{0, 0, b}, 0}
+	e := newKeyPressEmitter(v)
+	tests := []struct {
+		keys     []interface{}
+		modified bool
+	}{
+		{_cmd{}, false},
+		{_cmd{"x"}, true},
+		// undo back to the saved text
+		{_cmd{"u"}, false},
+		{_cmd{"x:w", KeyEnter}, false},
+		{_cmd{"u"}, true},
+		{_cmd{KeyCtrlR}, false},
+		// the text inserted before leaving insertMode
+		{_cmd{"ia"}, true},
+		{_cmd{KeyEsc, ":w", KeyEnter}, false},
+		{_cmd{":t.", KeyEnter}, true},
+		// writing a copy does not save the buffer
+		{_cmd{":w " + c, KeyEnter}, true},
+	}
+	for i, test := range tests {
+		e.emit(test.keys...)
+		if b.modified != test.modified {
+			t.Errorf("%v: expected modified %v", i, test.modified)
+		}
+	}
+	if title := v.title(); title != "a.txt [+]" {
+		t.Errorf("wrong title %q", title)
+	}
+
+	// quitting is refused while a buffer is modified
+	for _, keys := range []interface{}{":q", ",q"} {
+		e.emit(keys)
+		if keys == ":q" {
+			e.emit(KeyEnter)
+		}
+		if msg := string(be.msgLine); !strings.HasPrefix(msg, "No write since last change") {
+			t.Errorf("%v: wrong message %q", keys, msg)
+		}
+	}
+}
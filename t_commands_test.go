@@ -44,13 +44,13 @@ func TestLineMotions(t *testing.T) {
 		e := newKeyPressEmitter(v)
 		e.emit("G")
 		a.assert("G", "cs.pos", v.cs.pos, 0)
-		a.assert("G", "cs.line", v.cs.line, len(v.buf.text)-1)
+		a.assert("G", "cs.line", v.cs.line, v.buf.text.len()-1)
 		e.emit("gg")
 		a.assert("gg", "cs.pos", v.cs.pos, 0)
 		a.assert("gg", "cs.line", v.cs.line, 0)
 		// test '$', '0', 'L', 'H',
 		e.emit("$")
-		exp := len(v.buf.text[0]) - 2
+		exp := len(v.buf.text.line(0)) - 2
 		if exp < 0 {
 			exp = 0
 		}
@@ -668,3 +668,502 @@ func TestUndoRedoInsertWithBackspaceAndDelete(t *testing.T) {
 		}
 	}
 }
+
+func TestVisualMode(t *testing.T) {
+	num := 9
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "hello dude\nhow are you\n"
+	cmd[0] = _cmd{"lvlld"}
+	exp[0] = "ho dude\nhow are you\n"
+
+	str[1] = "hello dude\nhow are you\n"
+	cmd[1] = _cmd{"wvjd"}
+	exp[1] = "hello  you\n"
+
+	str[2] = "one\ntwo\nthree\nfour\n"
+	cmd[2] = _cmd{"jVjd"}
+	exp[2] = "one\nfour\n"
+
+	str[3] = "one\ntwo\nthree\nfour\n"
+	cmd[3] = _cmd{"jVjd", "u"}
+	exp[3] = "one\ntwo\nthree\nfour\n"
+
+	str[4] = "abcd\nefgh\nijkl\n"
+	cmd[4] = _cmd{"l", KeyCtrlV, "jld"}
+	exp[4] = "ad\neh\nijkl\n"
+
+	str[5] = "abcd\nefgh\nijkl\n"
+	cmd[5] = _cmd{"l", KeyCtrlV, "jld", "u"}
+	exp[5] = "abcd\nefgh\nijkl\n"
+
+	str[6] = "one\ntwo\nthree\n"
+	cmd[6] = _cmd{"Vj>", "jV<"}
+	exp[6] = "\tone\ntwo\nthree\n"
+
+	str[7] = "hello dude\n"
+	cmd[7] = _cmd{"vecbye", KeyCtrlC}
+	exp[7] = "bye dude\n"
+
+	str[8] = "hello dude\n"
+	cmd[8] = _cmd{"wvld", "u", KeyCtrlR}
+	exp[8] = "hello de\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestYankPut(t *testing.T) {
+	num := 10
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "one\ntwo\n"
+	cmd[0] = _cmd{"yyp"}
+	exp[0] = "one\none\ntwo\n"
+
+	str[1] = "one\ntwo\n"
+	cmd[1] = _cmd{"jyyP"}
+	exp[1] = "one\ntwo\ntwo\n"
+
+	str[2] = "one\ntwo\n"
+	cmd[2] = _cmd{"yyjp"}
+	exp[2] = "one\ntwo\none\n"
+
+	str[3] = "hello dude\n"
+	cmd[3] = _cmd{"ywP"}
+	exp[3] = "hello hello dude\n"
+
+	str[4] = "hello dude\n"
+	cmd[4] = _cmd{"yeLp"}
+	exp[4] = "hello dudehello\n"
+
+	str[5] = "one\ntwo\nthree\n"
+	cmd[5] = _cmd{"\"ayyj\"Ayyj\"ap"}
+	exp[5] = "one\ntwo\nthree\none\ntwo\n"
+
+	str[6] = "one\ntwo\nthree\n"
+	cmd[6] = _cmd{"ddp"}
+	exp[6] = "two\none\nthree\n"
+
+	str[7] = "one\ntwo\nthree\n"
+	cmd[7] = _cmd{"dddd\"2p"}
+	exp[7] = "three\none\n"
+
+	str[8] = "hello dude\n"
+	cmd[8] = _cmd{"dwyy\"-P"}
+	exp[8] = "hello dude\n"
+
+	str[9] = "one\n"
+	cmd[9] = _cmd{"yy3pu"}
+	exp[9] = "one\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestLastInsertRegister(t *testing.T) {
+	v := stringToView("\n")
+	e := newKeyPressEmitter(v)
+	e.emit("i", "hi ", KeyCtrlC, "\".p")
+	err := equalStrings(viewToString(v), "hi hi \n")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestRepeatLastChange(t *testing.T) {
+	num := 8
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "one\ntwo\n"
+	cmd[0] = _cmd{"A", " foo", KeyEsc, "j."}
+	exp[0] = "one foo\ntwo foo\n"
+
+	str[1] = "a b c d e f g\n"
+	cmd[1] = _cmd{"d2w."}
+	exp[1] = "e f g\n"
+
+	str[2] = "a b c d e f g\n"
+	cmd[2] = _cmd{"d2w3."}
+	exp[2] = "f g\n"
+
+	str[3] = "a b c d e f g\n"
+	cmd[3] = _cmd{"d2w3.."}
+	exp[3] = "\n"
+
+	str[4] = "1\n2\n3\n4\n5\n"
+	cmd[4] = _cmd{"dd.u"}
+	exp[4] = "2\n3\n4\n5\n"
+
+	str[5] = "one\ntwo\n"
+	cmd[5] = _cmd{"i", "ab", KeyBackspace, "c", KeyEsc, "j0."}
+	exp[5] = "acone\nactwo\n"
+
+	str[6] = "abcdef\n"
+	cmd[6] = _cmd{"x..u"}
+	exp[6] = "cdef\n"
+
+	str[7] = "one\ntwo\n"
+	cmd[7] = _cmd{"A", " foo", KeyEsc, "j.u"}
+	exp[7] = "one foo\ntwo\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestUndoRedoDelete(t *testing.T) {
+	num := 5
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "one two\nthree\n"
+	cmd[0] = _cmd{"dwu"}
+	exp[0] = "one two\nthree\n"
+
+	str[1] = "one two\nthree\n"
+	cmd[1] = _cmd{"wdwu", KeyCtrlR}
+	exp[1] = "one three\n"
+
+	str[2] = "one\ntwo\nthree\n"
+	cmd[2] = _cmd{"jdGu"}
+	exp[2] = "one\ntwo\nthree\n"
+
+	str[3] = "one\ntwo\nthree\n"
+	cmd[3] = _cmd{"jdggu"}
+	exp[3] = "one\ntwo\nthree\n"
+
+	str[4] = "one\ntwo\nthree\n"
+	cmd[4] = _cmd{"jdGu", KeyCtrlR}
+	exp[4] = "one\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	num := 10
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "one foo two foo\n"
+	cmd[0] = _cmd{"/foo", KeyEnter, "x"}
+	exp[0] = "one oo two foo\n"
+
+	str[1] = "one foo two foo\n"
+	cmd[1] = _cmd{"/foo", KeyEnter, "nx"}
+	exp[1] = "one foo two oo\n"
+
+	str[2] = "one foo two foo\n"
+	cmd[2] = _cmd{"/foo", KeyEnter, "nNx"}
+	exp[2] = "one oo two foo\n"
+
+	str[3] = "foo one\nfoo\n"
+	cmd[3] = _cmd{"/fo+", KeyEnter, "nx"}
+	exp[3] = "oo one\nfoo\n"
+
+	str[4] = "one two foo\n"
+	cmd[4] = _cmd{"d/foo", KeyEnter}
+	exp[4] = "foo\n"
+
+	str[5] = "foo one foo\n"
+	cmd[5] = _cmd{"$?foo", KeyEnter, "x"}
+	exp[5] = "foo one oo\n"
+
+	str[6] = "one two\n"
+	cmd[6] = _cmd{"/two", KeyEsc, "x"}
+	exp[6] = "ne two\n"
+
+	str[7] = "a foo b foo\n"
+	cmd[7] = _cmd{"/foo", KeyEnter, "/", KeyEnter, "x"}
+	exp[7] = "a foo b oo\n"
+
+	str[8] = "a b c b\n"
+	cmd[8] = _cmd{"/b", KeyEnter, "0dn"}
+	exp[8] = "b c b\n"
+
+	str[9] = "one foo\n"
+	cmd[9] = _cmd{"v/f", KeyEnter, "d"}
+	exp[9] = "oo\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	num := 15
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "one two one\none\n"
+	cmd[0] = _cmd{":s/one/1/", KeyEnter}
+	exp[0] = "1 two one\none\n"
+
+	str[1] = "one two one\none\n"
+	cmd[1] = _cmd{":%s/one/1/g", KeyEnter}
+	exp[1] = "1 two 1\n1\n"
+
+	str[2] = "a\na\na\na\n"
+	cmd[2] = _cmd{":2,3s/a/b/", KeyEnter}
+	exp[2] = "a\nb\nb\na\n"
+
+	str[3] = "a\na\na\na\n"
+	cmd[3] = _cmd{"j:.,$s/a/b/", KeyEnter}
+	exp[3] = "a\nb\nb\nb\n"
+
+	str[4] = "hello world\n"
+	cmd[4] = _cmd{`:s/(\w+) (\w+)/${2} $1/`, KeyEnter}
+	exp[4] = "world hello\n"
+
+	str[5] = "One one\n"
+	cmd[5] = _cmd{":s/one/1/gi", KeyEnter}
+	exp[5] = "1 1\n"
+
+	str[6] = "a a\na\n"
+	cmd[6] = _cmd{":%s/a/b/g", KeyEnter, "u"}
+	exp[6] = "a a\na\n"
+
+	str[7] = "a a\na a\n"
+	cmd[7] = _cmd{":%s/a/b/gc", KeyEnter, "ynyn"}
+	exp[7] = "b a\nb a\n"
+
+	str[8] = "a a\na a\n"
+	cmd[8] = _cmd{":%s/a/b/gc", KeyEnter, "na"}
+	exp[8] = "a b\nb b\n"
+
+	str[9] = "a\na\na\na\n"
+	cmd[9] = _cmd{"j:mark x", KeyEnter, "j:k y", KeyEnter, ":'x,'ys/a/b/", KeyEnter}
+	exp[9] = "a\nb\nb\na\n"
+
+	str[10] = "a\na\na\na\n"
+	cmd[10] = _cmd{"jVj:s/a/b/", KeyEnter}
+	exp[10] = "a\nb\nb\na\n"
+
+	str[11] = "foo x\nfoo\n"
+	cmd[11] = _cmd{"/x", KeyEnter, ":%s//y/", KeyEnter}
+	exp[11] = "foo y\nfoo\n"
+
+	str[12] = "aébaa\n"
+	cmd[12] = _cmd{":s/a*/-/g", KeyEnter}
+	exp[12] = "-é-b-\n"
+
+	str[13] = "ab\n"
+	cmd[13] = _cmd{":s/^/# /g", KeyEnter}
+	exp[13] = "# ab\n"
+
+	str[14] = "foofoo\n"
+	cmd[14] = _cmd{`:s/\bfoo/x/g`, KeyEnter}
+	exp[14] = "xfoo\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	v := stringToView("1\n2\n3\n4\n5\n")
+	v.cs.line = 2
+	v.buf.setMark('a', mark{1, 0, v.buf})
+	tests := []struct {
+		cmd      string
+		from, to int
+		rest     string
+	}{
+		{"s/a/b/", 2, 2, "s/a/b/"},
+		{"%s", 0, 4, "s"},
+		{".,$d", 2, 4, "d"},
+		{"2,4d", 1, 3, "d"},
+		{"'a,.d", 1, 2, "d"},
+		{".+1d", 3, 3, "d"},
+		{"$-3,-1d", 1, 1, "d"},
+		{"4,2d", 1, 3, "d"},
+	}
+	a := &asserter{}
+	for _, tt := range tests {
+		rg, rest, err := parseRange(*v.cs, tt.cmd)
+		a.assert(tt.cmd, "err", err, nil)
+		a.assert(tt.cmd, "from", rg.from, tt.from)
+		a.assert(tt.cmd, "to", rg.to, tt.to)
+		a.assert(tt.cmd, "rest", rest, tt.rest)
+	}
+	for _, cmd := range []string{"1,9d", "'b,.d"} {
+		_, _, err := parseRange(*v.cs, cmd)
+		a.assert(cmd, "err", err != nil, true)
+	}
+	if a.failed {
+		for _, m := range a.errMsgs {
+			t.Error(m)
+		}
+	}
+}
+
+func TestTextObjects(t *testing.T) {
+	num := 20
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "one two three\n"
+	cmd[0] = _cmd{"wldiw"}
+	exp[0] = "one  three\n"
+
+	str[1] = "one two three\n"
+	cmd[1] = _cmd{"wldaw"}
+	exp[1] = "one three\n"
+
+	str[2] = "one two\n"
+	cmd[2] = _cmd{"wdaw"}
+	exp[2] = "one\n"
+
+	str[3] = "a foo.bar b\n"
+	cmd[3] = _cmd{"wdiW"}
+	exp[3] = "a  b\n"
+
+	str[4] = "one two three\n"
+	cmd[4] = _cmd{"d2aw"}
+	exp[4] = "three\n"
+
+	str[5] = `x := "hello \"w\"" + y` + "\n"
+	cmd[5] = _cmd{"8ldi\""}
+	exp[5] = `x := "" + y` + "\n"
+
+	str[6] = "a 'b' c\n"
+	cmd[6] = _cmd{"da'"}
+	exp[6] = "a c\n"
+
+	str[7] = "f(a, (b), c)\n"
+	cmd[7] = _cmd{"3ldi("}
+	exp[7] = "f()\n"
+
+	str[8] = "f(a, (b), c)\n"
+	cmd[8] = _cmd{"6lda)"}
+	exp[8] = "f(a, , c)\n"
+
+	str[9] = "x[1] <a>\n"
+	cmd[9] = _cmd{"ldi[$di<"}
+	exp[9] = "x[] <>\n"
+
+	str[10] = "if x {\n\ta()\n\tb()\n}\n"
+	cmd[10] = _cmd{"jdi{"}
+	exp[10] = "if x {\n}\n"
+
+	str[11] = "if x {\n\ta()\n}\n"
+	cmd[11] = _cmd{"jda}"}
+	exp[11] = "if x \n"
+
+	str[12] = "a\nb\n\nc\n"
+	cmd[12] = _cmd{"dip"}
+	exp[12] = "\nc\n"
+
+	str[13] = "a\nb\n\nc\n"
+	cmd[13] = _cmd{"dap"}
+	exp[13] = "c\n"
+
+	str[14] = "a\n\nc\nd\n"
+	cmd[14] = _cmd{"Gdap"}
+	exp[14] = "a\n"
+
+	str[15] = "a\n\nc\n"
+	cmd[15] = _cmd{"yapGp"}
+	exp[15] = "a\n\nc\na\n\n"
+
+	str[16] = "One two. Three four. Five.\n"
+	cmd[16] = _cmd{"3wdis"}
+	exp[16] = "One two.  Five.\n"
+
+	str[17] = "One two. Three four. Five.\n"
+	cmd[17] = _cmd{"3wdas"}
+	exp[17] = "One two. Five.\n"
+
+	str[18] = "One two. Three\nfour. Five.\n"
+	cmd[18] = _cmd{"3wdas"}
+	exp[18] = "One two. Five.\n"
+
+	str[19] = "one two three\n"
+	cmd[19] = _cmd{"wdiwu"}
+	exp[19] = "one two three\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+func TestChange(t *testing.T) {
+	num := 14
+	str, exp := make([]string, num), make([]string, num)
+	cmd := make([][]interface{}, num)
+
+	str[0] = "one two three\n"
+	cmd[0] = _cmd{"wcwfoo", KeyEsc}
+	exp[0] = "one foo three\n"
+
+	str[1] = "one two three\n"
+	cmd[1] = _cmd{"wcwfoo", KeyEsc, "u"}
+	exp[1] = "one two three\n"
+
+	str[2] = "one two three\n"
+	cmd[2] = _cmd{"c2wfoo", KeyEsc}
+	exp[2] = "foo three\n"
+
+	str[3] = "one two three\n"
+	cmd[3] = _cmd{"wciwx", KeyEsc, "u"}
+	exp[3] = "one two three\n"
+
+	str[4] = "\tone\n\ttwo\nthree\n"
+	cmd[4] = _cmd{"2ccx", KeyEsc}
+	exp[4] = "\tx\nthree\n"
+
+	str[5] = "\tone\n\ttwo\nthree\n"
+	cmd[5] = _cmd{"2ccx", KeyEsc, "u"}
+	exp[5] = "\tone\n\ttwo\nthree\n"
+
+	str[6] = "one two\n"
+	cmd[6] = _cmd{"wCx", KeyEsc}
+	exp[6] = "one x\n"
+
+	str[7] = "one two\n"
+	cmd[7] = _cmd{"2sx", KeyEsc}
+	exp[7] = "xe two\n"
+
+	str[8] = "one two\n"
+	cmd[8] = _cmd{"2sx", KeyEsc, "u"}
+	exp[8] = "one two\n"
+
+	str[9] = "f(a, b)\n"
+	cmd[9] = _cmd{"2lci(x", KeyEsc}
+	exp[9] = "f(x)\n"
+
+	str[10] = "one two\none two\n"
+	cmd[10] = _cmd{"cwx", KeyEsc, "j0."}
+	exp[10] = "x two\nx two\n"
+
+	str[11] = "one two\n"
+	cmd[11] = _cmd{"cwx", KeyEsc, "u", KeyCtrlR}
+	exp[11] = "x two\n"
+
+	str[12] = "one two\n"
+	cmd[12] = _cmd{"wcwab", KeyBackspace, KeyBackspace, KeyBackspace, "c", KeyEsc, "u"}
+	exp[12] = "one two\n"
+
+	str[13] = "one two\n"
+	cmd[13] = _cmd{"wvlcx", KeyEsc, "u"}
+	exp[13] = "one two\n"
+
+	err := _testStrings(str, exp, cmd)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestToggleComment(t *testing.T) {
+	src := "func a() {\n\tif x {\n\t\ty()\n\n\t}\n}\n"
+	v := stringToView(src)
+	v.buf.filetype = _go
+	e := newKeyPressEmitter(v)
+	tests := []struct {
+		keys, exp string
+	}{
+		// the markers are aligned on the common indentation, blank lines
+		// are left alone
+		{"j4gcc", "func a() {\n\t// if x {\n\t// \ty()\n\n\t// }\n}\n"},
+		// lines all commented are uncommented
+		{"4gcc", src},
+		{"gcc", "func a() {\n\t// if x {\n\t\ty()\n\n\t}\n}\n"},
+		// some lines commented, all get commented
+		{"gcip", "// func a() {\n// \t// if x {\n// \t\ty()\n\n\t}\n}\n"},
+		{"u", "func a() {\n\t// if x {\n\t\ty()\n\n\t}\n}\n"},
+		{"u", src},
+		{"ggVjgc", "// func a() {\n// \tif x {\n\t\ty()\n\n\t}\n}\n"},
+		{"2gcc", src},
+	}
+	for _, test := range tests {
+		e.emit(test.keys)
+		if err := equalStrings(viewToString(v), test.exp); err != nil {
+			t.Errorf("%v: %v", test.keys, err)
+		}
+	}
+
+	// plain text has # comments, the other file types
+	// register their own
+	v = stringToView("a\n  b\n")
+	e = newKeyPressEmitter(v)
+	e.emit("gcG")
+	if err := equalStrings(viewToString(v), "# a\n#   b\n"); err != nil {
+		t.Error(err)
+	}
+	lisp := registerFiletype(&filetypeInfo{name: "lisp", tabStop: 2, comment: ";"},
+		[]string{".lisp"}, nil, nil)
+	v = stringToView("  a\n")
+	v.buf.setFiletype(lisp)
+	e = newKeyPressEmitter(v)
+	e.emit("gcc")
+	if err := equalStrings(viewToString(v), "  ; a\n"); err != nil {
+		t.Error(err)
+	}
+}
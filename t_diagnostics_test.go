@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-diags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fp := filepath.Join(dir, "a.go")
+	ioutil.WriteFile(fp, []byte("package m\n\nvar é = x\n"), 0644)
+	out := "# m\n./a.go:3:10: undefined: x\na.go:1:1: other\nexit status 2\n"
+	diags := goDiagnostics(dir, []byte(out), sevError)
+	if len(diags) != 1 || len(diags[fp]) != 2 {
+		t.Fatalf("wrong diagnostics: %v", diags)
+	}
+	// the files without a buffer are not opened, their buffers get the
+	// diagnostics once opened
+	setFileDiagnostics("go build", diags)
+	defer setFileDiagnostics("go build", nil)
+	if be.findBuffer(fp) != nil {
+		t.Fatal("buffer opened for the diagnostics")
+	}
+	b, err := be.bufferFor(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.removeBuffer(b)
+	list := b.diags.all()
+	if len(list) != 2 {
+		t.Fatalf("wrong diagnostics: %v", list)
+	}
+	if d := list[1]; d.start.line != 2 || d.start.pos != 8 || d.msg != "undefined: x" {
+		t.Errorf("wrong diagnostic %v at %v:%v", d, d.start.line, d.start.pos)
+	}
+	if d := list[0]; d.start.line != 0 || d.start.pos != 0 || d.msg != "other" {
+		t.Errorf("wrong diagnostic %v at %v:%v", d, d.start.line, d.start.pos)
+	}
+	// the diagnostics of the open buffers are replaced
+	setFileDiagnostics("go build", goDiagnostics(dir, []byte("a.go:2:1: blank\n"), sevError))
+	if list := b.diags.all(); len(list) != 1 || list[0].msg != "blank" {
+		t.Errorf("wrong diagnostics: %v", list)
+	}
+}
+
+func TestDiagnosticsNavigation(t *testing.T) {
+	v1 := stringToView("one\ntwo\nthree\n")
+	v2 := stringToView("four\nfive\n")
+	b1, b2 := v1.buf, v2.buf
+	// the diagnostics of these buffers only, in all the open buffers
+	defer func() {
+		b1.diags.set("test", nil)
+		b1.diags.set("other", nil)
+		b2.diags.set("test", nil)
+	}()
+	b1.diags.set("test", []diagnostic{
+		{mark{2, 1, b1}, mark{2, 3, b1}, sevWarning, "w"},
+		{mark{0, 0, b1}, mark{0, 2, b1}, sevError, "e"},
+	})
+	b1.diags.set("other", []diagnostic{{mark{2, 0, b1}, mark{2, 0, b1}, sevHint, "h"}})
+	b2.diags.set("test", []diagnostic{{mark{1, 2, b2}, mark{1, 2, b2}, sevInfo, "i"}})
+
+	if signs := b1.diags.signs(); len(signs) != 2 || signs[0] != sevError ||
+		signs[2] != sevWarning {
+		t.Errorf("wrong signs %v", signs)
+	}
+	if d, _ := b1.diags.at(mark{2, 2, b1}); d.msg != "w" {
+		t.Errorf("wrong diagnostic at cursor %v", d)
+	}
+	if d, _ := b1.diags.at(mark{2, 0, b1}); d.msg != "h" {
+		t.Errorf("wrong diagnostic at cursor %v", d)
+	}
+
+	e := newKeyPressEmitter(v1)
+	check := func(b *buffer, line, pos int, msg string) {
+		t.Helper()
+		if v1.buf != b || v1.cs.line != line || v1.cs.pos != pos {
+			t.Errorf("expected %v:%v, got %v:%v", line, pos, v1.cs.line, v1.cs.pos)
+		}
+		if got := string(be.msgLine); got != msg {
+			t.Errorf("expected message %q, got %q", msg, got)
+		}
+	}
+	e.emit("]d")
+	check(b1, 2, 0, "hint: h")
+	e.emit("]d")
+	check(b1, 2, 1, "warning: w")
+	e.emit("]d")
+	check(b2, 1, 2, "info: i")
+	e.emit("]d")
+	check(b1, 0, 0, "error: e")
+	e.emit("[d")
+	check(b2, 1, 2, "info: i")
+	e.emit("[d")
+	check(b1, 2, 1, "warning: w")
+	e.emit("2[d")
+	check(b1, 0, 0, "error: e")
+}
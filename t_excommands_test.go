@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExCommands(t *testing.T) {
+	tests := []struct {
+		src  string
+		keys []interface{}
+		exp  string
+	}{
+		{"1\n2\n3\n4\n", _cmd{":2,3d", KeyEnter}, "1\n4\n"},
+		{"1\n2\n3\n4\n", _cmd{":2,3d a", KeyEnter, "\"ap"}, "1\n4\n2\n3\n"},
+		{"1\n2\n3\n4\n", _cmd{":%d", KeyEnter, "u"}, "1\n2\n3\n4\n"},
+		{"1\n2\n3\n4\n", _cmd{":1,2m$", KeyEnter}, "3\n4\n1\n2\n"},
+		{"1\n2\n3\n4\n", _cmd{":3,4m0", KeyEnter}, "3\n4\n1\n2\n"},
+		{"1\n2\n3\n4\n", _cmd{":4m1", KeyEnter, "u"}, "1\n2\n3\n4\n"},
+		{"1\n2\n3\n", _cmd{":1t.", KeyEnter}, "1\n1\n2\n3\n"},
+		{"1\n2\n3\n", _cmd{":2,3co0", KeyEnter}, "2\n3\n1\n2\n3\n"},
+		{"1\n2\n3\n", _cmd{":%t$", KeyEnter, "u"}, "1\n2\n3\n"},
+		{"a\nb\nc\n", _cmd{":2,$>", KeyEnter}, "a\n\tb\n\tc\n"},
+		{"a\nb\n", _cmd{":%>>", KeyEnter, ":2<", KeyEnter}, "\t\ta\n\tb\n"},
+		{"a\nb\nc\n", _cmd{":%normal Ax", KeyEnter}, "ax\nbx\ncx\n"},
+		{"a\nb\nc\n", _cmd{":1,2norm yyp", KeyEnter}, "a\na\nb\nb\nc\n"},
+		{"a\nb\nc\n", _cmd{":%norm dd", KeyEnter}, "\n"},
+		// the changes of all the lines are one change
+		{"a\nb\nc\n", _cmd{":%normal Ax", KeyEnter, "u"}, "a\nb\nc\n"},
+		{"a\nb\nc\n", _cmd{":%norm yyp", KeyEnter, "u", KeyCtrlR}, "a\na\nb\nb\nc\nc\n"},
+		// pattern addresses, ';' makes the second one relative to the first
+		{"func a() {\n}\nfunc b() {\n\tx\n}\n",
+			_cmd{":/b()/;/^}/d", KeyEnter}, "func a() {\n}\n"},
+		{"x\na\nx\nb\n", _cmd{"G:?x?d", KeyEnter}, "x\na\nb\n"},
+		{"x\na\nx\nb\nx\n", _cmd{"/x", KeyEnter, ":.,//d", KeyEnter}, "x\na\n"},
+	}
+	for i, test := range tests {
+		v := stringToView(test.src)
+		newKeyPressEmitter(v).emit(test.keys...)
+		if err := equalStrings(viewToString(v), test.exp); err != nil {
+			t.Errorf("%v: %v", i, err)
+		}
+	}
+
+	v := stringToView("a\n")
+	e := newKeyPressEmitter(v)
+	for _, test := range []struct{ cmd, msg string }{
+		{":frob", "Unknown command: frob"},
+		{":0d", "Invalid range"},
+		{":9d", "Invalid range"},
+		{":/zz/d", "Pattern not found: zz"},
+		{":m5", "Invalid range"},
+		{":>x", "Usage: [range]>"},
+	} {
+		e.emit(test.cmd, KeyEnter)
+		if msg := string(be.msgLine); msg != test.msg {
+			t.Errorf("%v: expected message %q, got %q", test.cmd, test.msg, msg)
+		}
+	}
+}
+
+func TestExFileCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-ex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	ioutil.WriteFile(a, []byte("a1\na2\n"), 0644)
+
+	v := stringToView("1\n2\n3\n")
+	e := newKeyPressEmitter(v)
+	e.emit(":2,3w "+b, KeyEnter)
+	if content, _ := ioutil.ReadFile(b); string(content) != "2\n3\n" {
+		t.Errorf("wrong content written %q", content)
+	}
+	// writing lines to an existing file keeps its mode
+	os.Chmod(b, 0600)
+	e.emit(":2,3w "+b, KeyEnter)
+	if fi, err := os.Stat(b); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("wrong mode %v %v", fi.Mode(), err)
+	}
+	e.emit(":0r "+a, KeyEnter, ":$r "+b, KeyEnter)
+	if err := equalStrings(viewToString(v), "a1\na2\n1\n2\n3\n2\n3\n"); err != nil {
+		t.Error(err)
+	}
+
+	// saveas renames the buffer, e switches to another file and back
+	c := filepath.Join(dir, "c.go")
+	e.emit(":saveas "+c, KeyEnter)
+	if v.buf.filename != c || v.buf.filetype != _go {
+		t.Errorf("wrong buffer %v %v", v.buf.filename, v.buf.filetype.info().name)
+	}
+	if content, _ := ioutil.ReadFile(c); string(content) != "a1\na2\n1\n2\n3\n2\n3\n" {
+		t.Errorf("wrong content saved %q", content)
+	}
+	e.emit("/^1", KeyEnter, ":e "+a, KeyEnter)
+	if v.buf.filename != a || v.cs.line != 0 {
+		t.Errorf("wrong buffer %v:%v", v.buf.filename, v.cs.line)
+	}
+	e.emit(":e "+c, KeyEnter)
+	if v.buf.filename != c || v.cs.line != 2 {
+		t.Errorf("wrong buffer %v:%v", v.buf.filename, v.cs.line)
+	}
+
+	// e without a file reloads the buffer
+	ioutil.WriteFile(c, []byte("new\n"), 0644)
+	e.emit(":e", KeyEnter)
+	if err := equalStrings(viewToString(v), "new\n"); err != nil {
+		t.Error(err)
+	}
+	e.emit(":w", KeyEnter)
+	if msg := string(be.msgLine); msg != "file saved" {
+		t.Errorf("wrong message %q", msg)
+	}
+}
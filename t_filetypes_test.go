@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFiletype(t *testing.T) {
+	tests := []struct {
+		fp, firstLine, exp string
+	}{
+		{"/a/b.go", "package b", "go"},
+		{"/a/go.mod", "module a", "gomod"},
+		{"/a/Makefile", "all:", "make"},
+		{"/a/x.yml", "a: 1", "yaml"},
+		{"/a/README.md", "# Title", "markdown"},
+		{"/a/run", "#!/bin/sh", "shell"},
+		{"/a/run", "#!/usr/bin/env python3 -u", "python"},
+		{"/a/run.sh", "#!/usr/bin/env python3", "shell"},
+		{"/a/notes", "#!/usr/bin/unknown", "text"},
+		{"/a/notes", "text", "text"},
+	}
+	for _, test := range tests {
+		if ft := detectFiletype(test.fp, []byte(test.firstLine)); ft.info().name != test.exp {
+			t.Errorf("%v %q: expected %v, got %v", test.fp, test.firstLine, test.exp,
+				ft.info().name)
+		}
+	}
+}
+
+func TestFiletypeSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-filetypes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	yml := filepath.Join(dir, "a.yml")
+	ioutil.WriteFile(yml, []byte("a:\n  b: 1\n"), 0644)
+	b, err := be.bufferFor(yml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.tabStop != 2 || !b.expandTab {
+		t.Errorf("expected 2 spaces for yaml, got %v %v", b.tabStop, b.expandTab)
+	}
+	// the indentation and the shifts of a buffer use its own settings
+	v := &view{b, &mark{1, 0, b}, 0}
+	e := newKeyPressEmitter(v)
+	e.emit("A", KeyEnter, "c: 2", KeyEsc, "Vk>")
+	if err := equalStrings(viewToString(v), "a:\n    b: 1\n    c: 2\n"); err != nil {
+		t.Error(err)
+	}
+	v = stringToView("a\n")
+	v.buf.setFiletype(_go)
+	e = newKeyPressEmitter(v)
+	e.emit("V>")
+	if err := equalStrings(viewToString(v), "\ta\n"); err != nil {
+		t.Error(err)
+	}
+
+	// markdown has no line comments
+	v = stringToView("a\n")
+	v.buf.setFiletype(detectFiletype("a.md", nil))
+	e = newKeyPressEmitter(v)
+	e.emit("gcc")
+	if msg := string(be.msgLine); msg != "No line comments in markdown" {
+		t.Errorf("wrong message %q", msg)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		base, a, b, exp string
+		conflicts       int
+	}{
+		{"1 2 3 4 5", "1 2a 3 4 5", "1 2 3 4b 5", "1 2a 3 4b 5", 0},
+		{"1 2 3", "0 1 2 3", "1 2 3 4", "0 1 2 3 4", 0},
+		{"1 2 3", "1 3", "1 2 3 4", "1 3 4", 0},
+		{"1 2 3", "1 x 3", "1 x 3", "1 x 3", 0},
+		{"1 2 3", "1 x 3", "1 2 y", "1 x y", 0},
+		{"1 2", "1 a 2", "1 b 2", "1 <<<<<<< a ======= b >>>>>>> 2", 1},
+		{"1 2 3", "1 a 3", "1 b 3", "1 <<<<<<< a ======= b >>>>>>> 3", 1},
+	}
+	split := func(s string) []string {
+		var lines []string
+		for _, w := range strings.Fields(s) {
+			lines = append(lines, w+"\n")
+		}
+		return lines
+	}
+	for _, test := range tests {
+		merged, conflicts := merge3(split(test.base), split(test.a), split(test.b))
+		got := strings.Join(strings.Fields(strings.Join(merged, "")), " ")
+		got = strings.Replace(got, "<<<<<<< buffer", "<<<<<<<", 1)
+		got = strings.Replace(got, ">>>>>>> file", ">>>>>>>", 1)
+		if got != test.exp || conflicts != test.conflicts {
+			t.Errorf("%v / %v / %v: expected %q with %v conflicts, got %q with %v",
+				test.base, test.a, test.b, test.exp, test.conflicts, got, conflicts)
+		}
+	}
+}
+
+func TestExternalChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-filewatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fp := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(fp, []byte("1\n2\n3\n"), 0644)
+	b, err := be.bufferFor(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &view{b, &mark{0, 0, b}, 0}
+	e := newKeyPressEmitter(v)
+	// the files of the buffers of the other tests are checked first
+	e.emit(":checktime", KeyEnter)
+	// the file changes a second later each time so that its time changes
+	mtime := time.Now()
+	change := func(content string) {
+		ioutil.WriteFile(fp, []byte(content), 0644)
+		mtime = mtime.Add(time.Second)
+		os.Chtimes(fp, mtime, mtime)
+	}
+	check := func(title, text, msg string, modified bool) {
+		if err := equalStrings(viewToString(v), text); err != nil {
+			t.Errorf("%v: %v", title, err)
+		}
+		if got := string(be.msgLine); got != msg {
+			t.Errorf("%v: expected message %q, got %q", title, msg, got)
+		}
+		if b.modified != modified {
+			t.Errorf("%v: expected modified %v", title, modified)
+		}
+	}
+
+	// a buffer not modified is reloaded, the cursor and the marks follow
+	// their lines
+	e.emit("/2", KeyEnter, ":mark a", KeyEnter, "/3", KeyEnter)
+	change("0\n1\n2\n3\n")
+	e.emit(":checktime", KeyEnter)
+	check("reload", "0\n1\n2\n3\n", "a.txt reloaded", false)
+	if v.cs.line != 3 || b.marks['a'].line != 2 {
+		t.Errorf("cursor on %v, mark on %v", v.cs.line, b.marks['a'].line)
+	}
+	e.emit("u")
+	check("undo reload", "1\n2\n3\n", "undid change #1 of 1", true)
+	e.emit(KeyCtrlR)
+
+	// a modified buffer is left alone, with a warning once
+	e.emit("/3", KeyEnter, "A!", KeyEsc)
+	change("0\n1\n2\n3\n4\n")
+	e.emit(":checktime", KeyEnter)
+	check("warning", "0\n1\n2\n3!\n", "a.txt changed on disk, :e! to reload it or "+
+		":merge to merge it", true)
+	e.emit(":checktime", KeyEnter)
+	check("no warning", "0\n1\n2\n3!\n", "", true)
+	e.emit(":merge", KeyEnter)
+	check("merge", "0\n1\n2\n3!\n4\n", "a.txt merged", true)
+	// undoing the local change does not give back the text of the file
+	e.emit("u")
+	check("undo merge", "0\n1\n2\n3!\n", "undid change #3 of 3", true)
+
+	e.emit(":e", KeyEnter)
+	check("e", "0\n1\n2\n3!\n", unsavedMessage(b), true)
+	change("0\n1\n2\n3\n4\n5\n")
+	e.emit(":e!", KeyEnter)
+	check("e!", "0\n1\n2\n3\n4\n5\n", "a.txt reloaded", false)
+}
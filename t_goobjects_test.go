@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var goObjectsSrc = strings.Join([]string{
+	"package main",
+	"",
+	"// T is a type",
+	"type T struct {",
+	"\ta int",
+	"}",
+	"",
+	"type (",
+	"\tU int",
+	"\tV []string",
+	")",
+	"",
+	"// f does",
+	"// things",
+	"func f() {",
+	"\ts := \"}\"",
+	"\tif s != \"\" {",
+	"\t\tg(func() { h() })",
+	"\t}",
+	"}",
+	"",
+}, "\n")
+
+func TestGoObjects(t *testing.T) {
+	lines := strings.Split(goObjectsSrc, "\n")
+	without := func(l1, l2 int) string {
+		return strings.Join(append(append([]string{}, lines[:l1]...), lines[l2+1:]...), "\n")
+	}
+	tests := []struct {
+		keys []interface{}
+		exp  string
+	}{
+		// the function with its doc comment, from the doc comment too
+		{[]interface{}{"gg16jdaf"}, without(12, 19)},
+		{[]interface{}{"gg12jdaf"}, without(12, 19)},
+		// the body, the brace in the string does not count
+		{[]interface{}{"gg15jdif"}, without(15, 18)},
+		// the innermost function is the literal
+		{[]interface{}{"gg17j/h()", KeyEnter, "dif"},
+			strings.Replace(goObjectsSrc, "func() { h() }", "func() {}", 1)},
+		// the innermost block, the braces included with ab
+		{[]interface{}{"gg17jdib"}, without(17, 17)},
+		{[]interface{}{"gg17j/h()", KeyEnter, "dab"},
+			strings.Replace(goObjectsSrc, "func() { h() }", "func() ", 1)},
+		// the type declaration, only the type in a group
+		{[]interface{}{"gg4jdat"}, without(2, 5)},
+		{[]interface{}{"gg9jdat"}, without(9, 9)},
+		{[]interface{}{"gg4jdit"}, without(4, 4)},
+		{[]interface{}{"gg9jwdit"}, strings.Replace(goObjectsSrc, "V []string", "V ", 1)},
+		// no object, no change
+		{[]interface{}{"ggdaf"}, goObjectsSrc},
+		{[]interface{}{"ggdat"}, goObjectsSrc},
+		// operators with ]] stop before the declaration
+		{[]interface{}{"gg3jd]]"}, without(3, 6)},
+	}
+	for _, test := range tests {
+		v := stringToView(goObjectsSrc)
+		v.buf.filetype = _go
+		e := newKeyPressEmitter(v)
+		e.emit(test.keys...)
+		if err := equalStrings(viewToString(v), test.exp); err != nil {
+			t.Errorf("%v: %v", test.keys, err)
+		}
+	}
+
+	// ]] and [[ go through the top level declarations
+	v := stringToView(goObjectsSrc)
+	v.buf.filetype = _go
+	e := newKeyPressEmitter(v)
+	for _, exp := range []int{3, 7, 14, 14} {
+		e.emit("]]")
+		if v.cs.line != exp {
+			t.Errorf("]]: expected line %v, got %v", exp, v.cs.line)
+		}
+	}
+	e.emit("2[[")
+	if v.cs.line != 3 {
+		t.Errorf("2[[: expected line 3, got %v", v.cs.line)
+	}
+
+	// outside Go buffers ib is the {} object and ]] goes to lines starting
+	// with {
+	v = stringToView("a\n{\nb {\n c\n}\n}\n{\n")
+	e = newKeyPressEmitter(v)
+	e.emit("3jdib")
+	if err := equalStrings(viewToString(v), "a\n{\nb {\n}\n}\n{\n"); err != nil {
+		t.Error(err)
+	}
+	e.emit("gg]]]]")
+	if v.cs.line != 5 {
+		t.Errorf("]] without Go: expected line 5, got %v", v.cs.line)
+	}
+}
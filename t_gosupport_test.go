@@ -1,42 +1,181 @@
 package main
 
-import "testing"
-
-func TestGoSupport(t *testing.T) {
-	a := &asserter{}
-	// test openBlock
-	ss := []string{
-		"func a() {",
-		"func a() {  ",
-		"var (",
-		"var ( \t",
-		"case xx == yy:",
-	}
-	for _, s := range ss {
-		a.assert("openBlock", s, openBlock.Match([]byte(s)), true)
-	}
-	// test closeBlock
-	ss = []string{
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoIndent(t *testing.T) {
+	// every line is indented from the text above it as gofmt does, the lines
+	// inside raw strings and comments keep their indentation
+	src := []string{
+		"package main",
+		"",
+		"func a(x int,",
+		"\ty int) {",
+		"\tswitch {",
+		"\tcase x > 0 &&",
+		"\t\ty > 0:",
+		"\t\tfmt.Println(`raw",
+		"  { kept",
+		"}`, []int{",
+		"\t\t\t1, 2,",
+		"\t\t})",
+		"\tdefault:",
+		"\t\t/* a",
+		"     comment */",
+		"\t}",
+		"loop:",
+		"\tfor {",
+		"\t\tselect {",
+		"\t\tcase <-c:",
+		"\t\t\tbreak loop // done",
+		"\t\t}",
+		"\t}",
 		"}",
-		")",
-		"  )",
-		"\t  }",
-		"  \t\t }",
 	}
-	for _, s := range ss {
-		a.assert("closeBlock", s, closeBlock.Match([]byte(s)), true)
+	v := stringToView(strings.Join(src, "\n") + "\n")
+	v.buf.filetype = _go
+	exp := []int{0, 0, 0, 4, 4, 4, 8, 8, -1, -1, 12, 8, 4, 8, -1, 4, 0, 4, 8, 8, 12, 8, 4, 0}
+	if got := goIndents(v.buf, 0, len(src)-1); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected indents %v, got %v", exp, got)
+	}
+	for ln := range src {
+		m := mark{ln, 0, v.buf}
+		if got := goindent(&m); got != exp[ln] {
+			t.Errorf("line %v: expected indent %v, got %v", ln, exp[ln], got)
+		}
+	}
+
+	// = re-indents a region as one change
+	unindented := make([]string, len(src))
+	for i, s := range src {
+		unindented[i] = s
+		if exp[i] >= 0 {
+			unindented[i] = strings.TrimLeft(s, "\t")
+		}
+	}
+	v = stringToView(strings.Join(unindented, "\n") + "\n")
+	v.buf.filetype = _go
+	e := newKeyPressEmitter(v)
+	e.emit("=G")
+	if err := equalStrings(viewToString(v), strings.Join(src, "\n")+"\n"); err != nil {
+		t.Error(err)
+	}
+	e.emit("u")
+	if err := equalStrings(viewToString(v), strings.Join(unindented, "\n")+"\n"); err != nil {
+		t.Error(err)
+	}
+	// a count of lines with ==, the selection in visual mode
+	e.emit("jj3==")
+	if got := viewToString(v); !strings.Contains(got, "func a(x int,\n\ty int) {\n\tswitch {\ncase") {
+		t.Errorf("wrong text after 3==: %q", got)
 	}
-	// test caseStatement
-	ss = []string{
-		"   case xxx == yyy:   ",
-		"\t\tdefault:",
+	e.emit("ggVG=")
+	if err := equalStrings(viewToString(v), strings.Join(src, "\n")+"\n"); err != nil {
+		t.Error(err)
 	}
-	for _, s := range ss {
-		a.assert("caseStatement", s, caseStatement.Match([]byte(s)), true)
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		exp  []hunk
+	}{
+		{"a b c", "a b c", nil},
+		{"a b c", "a x c", []hunk{{1, 2, 1, 2}}},
+		{"a b c d", "a c d e", []hunk{{1, 2, 1, 1}, {4, 4, 3, 4}}},
+		{"a b c", "x a b y c z", []hunk{{0, 0, 0, 1}, {2, 2, 3, 4}, {3, 3, 5, 6}}},
+		{"a b", "", []hunk{{0, 2, 0, 0}}},
 	}
-	if a.failed {
-		for _, m := range a.errMsgs {
-			t.Error(m)
+	for _, test := range tests {
+		hunks := diffLines(strings.Fields(test.a), strings.Fields(test.b))
+		if !reflect.DeepEqual(hunks, test.exp) {
+			t.Errorf("%q to %q: expected %v, got %v", test.a, test.b, test.exp, hunks)
 		}
 	}
+	hunks := []hunk{{1, 2, 1, 4}, {5, 7, 7, 7}}
+	for ln, exp := range []int{0, 1, 4, 5, 6, 7, 7, 7} {
+		if got := mapLine(hunks, ln); got != exp {
+			t.Errorf("mapLine %v: expected %v, got %v", ln, exp, got)
+		}
+	}
+}
+
+// largeDiff returns n lines and the same lines with every step-th one
+// changed
+func largeDiff(n, step int) (a, b []string) {
+	a, b = make([]string, n), make([]string, n)
+	for i := range a {
+		a[i] = fmt.Sprintf("line %v\n", i)
+		b[i] = a[i]
+		if i%step == step/2 {
+			b[i] = "changed\n"
+		}
+	}
+	return a, b
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// the hunks of a large text with many changes
+	a, b := largeDiff(200000, 250)
+	hunks := diffLines(a, b)
+	if len(hunks) != 800 {
+		t.Fatalf("expected 800 hunks, got %v", len(hunks))
+	}
+	for i, h := range hunks {
+		if ln := i*250 + 125; h != (hunk{ln, ln + 1, ln, ln + 1}) {
+			t.Fatalf("wrong hunk %v: %v", i, h)
+		}
+	}
+	// too many changes are replaced as a whole
+	a, b = largeDiff(10000, 2)
+	hunks = diffLines(a, b)
+	if exp := []hunk{{1, 10000, 1, 10000}}; !reflect.DeepEqual(hunks, exp) {
+		t.Errorf("expected %v, got %v", exp, hunks)
+	}
+}
+
+func BenchmarkDiffLines(b *testing.B) {
+	x, y := largeDiff(200000, 250)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		diffLines(x, y)
+	}
+}
+
+func TestGofmt(t *testing.T) {
+	if _, err := exec.LookPath("gofmt"); err != nil {
+		t.Skip("gofmt not installed")
+	}
+	// the unsaved text is formatted, the cursor stays on its line
+	v := stringToView("package main\nfunc  a() {\nx:=1\n\n\n}\n// end\n")
+	e := newKeyPressEmitter(v)
+	e.emit("G:mark a", KeyEnter, ":gofmt", KeyEnter)
+	if err := equalStrings(viewToString(v), "package main\n\nfunc a() {\n\tx := 1\n\n}\n\n// end\n"); err != nil {
+		t.Error(err)
+	}
+	if v.cs.line != 7 || v.buf.marks['a'].line != 7 {
+		t.Errorf("cursor or mark moved to lines %v and %v", v.cs.line, v.buf.marks['a'].line)
+	}
+	// the whole format is one change
+	e.emit("u")
+	if err := equalStrings(viewToString(v), "package main\nfunc  a() {\nx:=1\n\n\n}\n// end\n"); err != nil {
+		t.Error(err)
+	}
+
+	// syntax errors are reported and the text is not touched
+	v = stringToView("package main\nfunc {\n")
+	e = newKeyPressEmitter(v)
+	e.emit(":gofmt", KeyEnter)
+	if msg := string(be.msgLine); !strings.HasPrefix(msg, filepath.Base(v.buf.filename)+":2:") {
+		t.Errorf("wrong message: %v", msg)
+	}
+	if err := equalStrings(viewToString(v), "package main\nfunc {\n"); err != nil {
+		t.Error(err)
+	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTestArgs(t *testing.T) {
+	tests := []struct {
+		tests, benchmarks []string
+		exp               string
+	}{
+		{nil, nil, "test ."},
+		{[]string{"TestA"}, nil, "test -run ^(TestA)$ ."},
+		{nil, []string{"BenchmarkA"}, "test -run ^$ -bench ^(BenchmarkA)$ ."},
+		{[]string{"TestA", "TestB"}, []string{"BenchmarkA"},
+			"test -run ^(TestA|TestB)$ -bench ^(BenchmarkA)$ ."},
+	}
+	for _, test := range tests {
+		if args := strings.Join(testArgs(test.tests, test.benchmarks), " "); args != test.exp {
+			t.Errorf("expected %q, got %q", test.exp, args)
+		}
+	}
+}
+
+func TestGoTest(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not installed")
+	}
+	dir, err := ioutil.TempDir("", "editor-gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := "package m\n\nimport \"testing\"\n\n" +
+		"func TestOk(t *testing.T) {\n}\n\n" +
+		"func TestFail(t *testing.T) {\n\tt.Error(\"failed\")\n}\n\n" +
+		"func helper() {}\n"
+	fp := filepath.Join(dir, "m_test.go")
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module m\n"), 0644)
+	ioutil.WriteFile(fp, []byte(src), 0644)
+	v := stringToView(src)
+	v.buf.filename, v.buf.filetype = fp, _go
+	e := newKeyPressEmitter(v)
+	checkMsg := func(exp string) {
+		t.Helper()
+		if msg := string(be.msgLine); msg != exp {
+			t.Errorf("expected message %q, got %q", exp, msg)
+		}
+	}
+
+	if tests, _ := testFuncs(v.buf); !reflect.DeepEqual(tests, []string{"TestOk", "TestFail"}) {
+		t.Errorf("wrong test functions %v", tests)
+	}
+	e.emit("G:gotest", KeyEnter)
+	checkMsg("No test function at the cursor")
+
+	// the test under the cursor, its failure is a location
+	e.emit("gg8j,t")
+	waitAsync()
+	checkMsg("gotest: 1 failures")
+	out := viewToString(&view{testOutput.buffer(), &mark{}, 0})
+	if !strings.HasPrefix(out, "go test -run ^(TestFail)$ .\n") ||
+		!strings.Contains(out, "m_test.go:9: failed") {
+		t.Errorf("wrong output %q", out)
+	}
+	if r.quickfix.len() != 1 {
+		t.Errorf("expected 1 quickfix entry, got %v", r.quickfix.len())
+	}
+	e.emit(":cn", KeyEnter)
+	checkMsg("(1 of 1) failed")
+	if v.cs.line != 8 {
+		t.Errorf("expected the failure line, got %v", v.cs.line)
+	}
+
+	// the last run again with one key, then the tests of the file
+	e.emit("gg", KeyF5)
+	waitAsync()
+	checkMsg("gotest: 1 failures")
+	e.emit(":gotest file", KeyEnter)
+	waitAsync()
+	checkMsg("gotest: 1 failures")
+	if !strings.HasPrefix(viewToString(&view{testOutput.buffer(), &mark{}, 0}),
+		"go test -run ^(TestOk|TestFail)$ .\n") {
+		t.Errorf("the file tests were not run")
+	}
+
+	// Enter on a location in the output jumps to it
+	tv := &view{testOutput.buffer(), &mark{}, 0}
+	tv.cs.buf = tv.buf
+	for ln := 0; ln < tv.buf.text.len(); ln++ {
+		if strings.Contains(string(tv.buf.text.line(ln)), "m_test.go:9:") {
+			tv.cs.line = ln
+		}
+	}
+	newKeyPressEmitter(tv).emit(KeyEnter)
+	if tv.buf.filename != fp || tv.cs.line != 8 {
+		t.Errorf("not on the failure but at %v:%v", tv.buf.filename, tv.cs.line)
+	}
+}
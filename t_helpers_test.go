@@ -13,6 +13,8 @@ func TestLineIndent(t *testing.T) {
 		" \t \n" +
 		"thanks\n"
 
+	v := stringToView(s)
+	tabStop := v.buf.tabStop
 	res := [][]int{
 		[]int{1 * tabStop, 1},
 		[]int{3 * tabStop, 3},
@@ -24,8 +26,7 @@ func TestLineIndent(t *testing.T) {
 		[]int{0, 0},
 	}
 	a := &asserter{}
-	v := stringToView(s)
-	for i := range v.buf.text {
+	for i := 0; i < v.buf.text.len(); i++ {
 		indent, indentChars := lineIndent(v.buf, i)
 		a.assert(string(i), "", indent, res[i][0])
 		a.assert(string(i), "", indentChars, res[i][1])
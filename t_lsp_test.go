@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLSPEnv is set in the environment of the test binary when it is run as
+// a fake language server
+const fakeLSPEnv = "EDITOR_FAKE_LSP"
+
+// fakeLSPServer is a minimal language server that knows only the words of
+// the open files: a word is defined by its first occurrence
+func fakeLSPServer(in io.Reader, out io.Writer) int {
+	r := bufio.NewReader(in)
+	docs := map[string]string{}
+	write := func(m *lspMessage) {
+		m.JSONRPC = "2.0"
+		body, _ := json.Marshal(m)
+		fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	var initID *json.RawMessage
+	for {
+		m, err := readLSPMessage(r)
+		if err != nil {
+			return 1
+		}
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+			Position lspPosition `json:"position"`
+			NewName  string      `json:"newName"`
+		}
+		json.Unmarshal(m.Params, &p)
+		uri := p.TextDocument.URI
+		occurrences := func() []lspLocation {
+			return fakeOccurrences(uri, docs[uri], p.Position)
+		}
+		var result interface{}
+		switch m.Method {
+		case "initialize":
+			// we answer once the client answered our own request
+			initID = m.ID
+			id := json.RawMessage(`"config"`)
+			write(&lspMessage{ID: &id, Method: "workspace/configuration",
+				Params: json.RawMessage(`{"items":[]}`)})
+			continue
+		case "":
+			if m.ID != nil && string(*m.ID) == `"config"` {
+				res, _ := json.Marshal(map[string]interface{}{"capabilities": struct{}{}})
+				write(&lspMessage{ID: initID, Result: res})
+			}
+			continue
+		case "textDocument/didOpen":
+			docs[uri] = p.TextDocument.Text
+			write(fakeDiagnostics(uri, docs[uri]))
+		case "textDocument/didChange":
+			docs[uri] = p.ContentChanges[len(p.ContentChanges)-1].Text
+			write(fakeDiagnostics(uri, docs[uri]))
+		case "textDocument/definition":
+			if occ := occurrences(); len(occ) > 0 {
+				result = occ[0]
+			}
+		case "textDocument/hover":
+			if occ := occurrences(); len(occ) > 0 {
+				rg := occ[0].Range
+				ln := strings.Split(docs[uri], "\n")[rg.Start.Line]
+				result = map[string]interface{}{"contents": map[string]string{
+					"kind": "markdown", "value": "```go\n" + ln + "\n```\n\ndoc"}}
+			}
+		case "textDocument/references":
+			result = occurrences()
+		case "textDocument/completion":
+			result = map[string]interface{}{"isIncomplete": false,
+				"items": fakeCompletions(docs[uri], p.Position)}
+		case "textDocument/rename":
+			var edits []lspTextEdit
+			for _, l := range occurrences() {
+				edits = append(edits, lspTextEdit{l.Range, p.NewName})
+			}
+			result = map[string]interface{}{"documentChanges": []interface{}{
+				map[string]interface{}{"textDocument": map[string]interface{}{
+					"uri": uri, "version": 1}, "edits": edits}}}
+		case "exit":
+			return 0
+		}
+		if m.ID != nil {
+			res, _ := json.Marshal(result)
+			write(&lspMessage{ID: m.ID, Result: res})
+		}
+	}
+}
+
+// fakeDiagnostics returns the notification of an error for each ERROR in
+// text
+func fakeDiagnostics(uri, text string) *lspMessage {
+	diags := []map[string]interface{}{}
+	for i, ln := range strings.Split(text, "\n") {
+		if c := strings.Index(ln, "ERROR"); c >= 0 {
+			diags = append(diags, map[string]interface{}{
+				"range":    lspRange{lspPosition{i, c}, lspPosition{i, c + 5}},
+				"severity": 1,
+				"message":  "bad code",
+			})
+		}
+	}
+	params, _ := json.Marshal(map[string]interface{}{"uri": uri, "diagnostics": diags})
+	return &lspMessage{Method: "textDocument/publishDiagnostics", Params: params}
+}
+
+// fakeOccurrences returns the locations of the word at position p in text
+func fakeOccurrences(uri, text string, p lspPosition) (locs []lspLocation) {
+	lines := strings.Split(text, "\n")
+	if p.Line >= len(lines) {
+		return nil
+	}
+	word := regexp.MustCompile(`\w+`)
+	var w string
+	for _, m := range word.FindAllStringIndex(lines[p.Line], -1) {
+		if m[0] <= p.Character && p.Character < m[1] {
+			w = lines[p.Line][m[0]:m[1]]
+		}
+	}
+	if w == "" {
+		return nil
+	}
+	re := regexp.MustCompile(`\b` + w + `\b`)
+	for i, ln := range lines {
+		for _, m := range re.FindAllStringIndex(ln, -1) {
+			locs = append(locs, lspLocation{uri, lspRange{lspPosition{i, m[0]},
+				lspPosition{i, m[1]}}})
+		}
+	}
+	return locs
+}
+
+// fakeCompletions returns the items for the words of text starting with the
+// word before position p, but this one, in the order of their first
+// occurrence
+func fakeCompletions(text string, p lspPosition) (items []map[string]string) {
+	lines := strings.Split(text, "\n")
+	if p.Line >= len(lines) || p.Character > len(lines[p.Line]) {
+		return nil
+	}
+	ln := lines[p.Line]
+	prefix := regexp.MustCompile(`\w*$`).FindString(ln[:p.Character])
+	lines[p.Line] = ln[:p.Character-len(prefix)] + ln[p.Character:]
+	seen := map[string]bool{}
+	for _, w := range regexp.MustCompile(`\w+`).FindAllString(strings.Join(lines, "\n"), -1) {
+		if strings.HasPrefix(w, prefix) && !seen[w] {
+			seen[w] = true
+			items = append(items, map[string]string{"label": w})
+		}
+	}
+	return items
+}
+
+func TestLSP(t *testing.T) {
+	defer func(s []string) { lspServer = s }(lspServer)
+	lspServer = []string{os.Args[0]}
+	os.Setenv(fakeLSPEnv, "1")
+	defer os.Unsetenv(fakeLSPEnv)
+	defer waitPosted()
+	defer stopLSPClients()
+
+	dir, err := ioutil.TempDir("", "editor-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// files written without buffers, which would be found by bufferFor
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module m\n"), 0644)
+	src := "package m\n\nvar count int\n\nfunc f() int {\n\treturn count\n}\n"
+	fp := filepath.Join(dir, "a.go")
+	ioutil.WriteFile(fp, []byte(src), 0644)
+	if root := moduleRoot(filepath.Join(dir, "sub", "pkg")); root != dir {
+		t.Errorf("wrong module root: %v", root)
+	}
+
+	b, err := be.bufferFor(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &view{b, newMark(b), 0}
+	e := newKeyPressEmitter(v)
+	checkMsg := func(exp string) {
+		t.Helper()
+		if msg := string(be.msgLine); msg != exp {
+			t.Errorf("expected message %q, got %q", exp, msg)
+		}
+	}
+	e.emit("5j$", "gd")
+	if v.cs.line != 2 || v.cs.pos != 4 {
+		t.Errorf("definition at %v:%v", v.cs.line, v.cs.pos)
+	}
+	e.emit("K")
+	checkMsg("var count int")
+	e.emit(":refs", KeyEnter)
+	checkMsg("2 references: a.go:3:5 a.go:6:9")
+
+	// rename is a single change
+	e.emit(":rename n", KeyEnter)
+	checkMsg("2 occurrences renamed in 1 files")
+	if err := equalStrings(viewToString(v), strings.Replace(src, "count", "n", 2)); err != nil {
+		t.Error(err)
+	}
+	e.emit("u")
+	if err := equalStrings(viewToString(v), src); err != nil {
+		t.Error(err)
+	}
+
+	// the server gets the changes of the buffer
+	e.emit("gg5j$", "ciwtotal", KeyEsc, "K")
+	checkMsg("return total")
+	src = strings.Replace(src, "return count", "return total", 1)
+
+	// completion replaces the word before the cursor, the keys again move in
+	// the list; the items are undone with the text inserted
+	e.emit("GA", KeyEnter, "f", KeyCtrlN)
+	checkMsg("match 1 of 2")
+	e.emit(KeyCtrlN)
+	checkMsg("match 2 of 2")
+	e.emit(KeyCtrlN, KeyCtrlP, "()", KeyEnter, "ret", KeyCtrlP)
+	checkMsg("match 1 of 1")
+	e.emit(KeyEsc)
+	if err := equalStrings(viewToString(v), src+"f()\nreturn\n"); err != nil {
+		t.Error(err)
+	}
+	e.emit("u")
+	if err := equalStrings(viewToString(v), src); err != nil {
+		t.Error(err)
+	}
+
+	// a file which is not Go has no server
+	waitPosted()
+	v = stringToView("text\n")
+	e = newKeyPressEmitter(v)
+	e.emit("K")
+	checkMsg("No language server for this file")
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	defer func(s []string) { lspServer = s }(lspServer)
+	lspServer = []string{os.Args[0]}
+	os.Setenv(fakeLSPEnv, "1")
+	defer os.Unsetenv(fakeLSPEnv)
+	defer waitPosted()
+	defer stopLSPClients()
+
+	dir, err := ioutil.TempDir("", "editor-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fp := filepath.Join(dir, "a.go")
+	ioutil.WriteFile(fp, []byte("package m\n\nvar x = ERROR\n"), 0644)
+	b, err := be.bufferFor(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &view{b, newMark(b), 0}
+	e := newKeyPressEmitter(v)
+	// the diagnostics are published asynchronously
+	waitDiagnostics := func(n int) []diagnostic {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if diags := b.diags.all(); len(diags) == n {
+				return diags
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("expected %v diagnostics, got %v", n, len(b.diags.all()))
+		return nil
+	}
+	e.emit("K")
+	diags := waitDiagnostics(1)
+	if d := diags[0]; d.start.line != 2 || d.start.pos != 8 || d.severity != sevError ||
+		d.String() != "error: bad code" {
+		t.Errorf("wrong diagnostic %v at %v:%v", d, d.start.line, d.start.pos)
+	}
+	// the changes are sent to the server once out of insertMode
+	e.emit("GA", KeyEnter, "ERROR")
+	time.Sleep(50 * time.Millisecond)
+	waitDiagnostics(1)
+	e.emit(KeyEsc)
+	waitDiagnostics(2)
+	e.emit("dd", "dd")
+	waitDiagnostics(0)
+}
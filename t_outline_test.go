@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutline(t *testing.T) {
+	src := "package m\n\n" +
+		"const c = 1\n\n" +
+		"var (\n\tv1, v2 int\n)\n\n" +
+		"type T struct{}\n\n" +
+		"func (t *T) M() {}\n\n" +
+		"func f() {}\n\n" +
+		"func (T) String() string { return \"\" }\n"
+	v := stringToView(src)
+	v.buf.filetype = _go
+	e := newKeyPressEmitter(v)
+	e.emit(":outline", KeyEnter)
+	exp := "funcs\n" +
+		"    f      13\n" +
+		"methods of T\n" +
+		"    M      11\n" +
+		"    String 15\n" +
+		"types\n" +
+		"    T      9\n" +
+		"consts\n" +
+		"    c      3\n" +
+		"vars\n" +
+		"    v1     6\n" +
+		"    v2     6\n"
+	ov := &view{outlines.buf, &mark{0, 0, outlines.buf}, 0}
+	if err := equalStrings(viewToString(ov), exp); err != nil {
+		t.Error(err)
+	}
+	// Enter on an entry moves the cursor of the outlined view, not on a header
+	ov.cs.line = 4
+	newKeyPressEmitter(ov).emit(KeyEnter)
+	if v.cs.line != 14 || v.cs.pos != 9 {
+		t.Errorf("expected the cursor on String, got %v:%v", v.cs.line, v.cs.pos)
+	}
+	ov.cs.line = 2
+	newKeyPressEmitter(ov).emit(KeyEnter)
+	if v.cs.line != 14 {
+		t.Errorf("the cursor moved to line %v", v.cs.line)
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	if _, ok := fuzzyScore("xyz", "NewView"); ok {
+		t.Error("xyz matches NewView")
+	}
+	// the better matches first
+	ordered := []string{"View", "NewView", "newValueIterator", "everyInvalidWord"}
+	last := -1
+	for _, s := range ordered {
+		score, ok := fuzzyScore("vi", s)
+		if !ok || score <= last {
+			t.Errorf("%v: score %v after %v", s, score, last)
+		}
+		last = score
+	}
+}
+
+func TestSym(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-sym")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, "testdata"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module m\n"), 0644)
+	a := filepath.Join(dir, "a.go")
+	ioutil.WriteFile(a, []byte("package m\n\nfunc openFile() {}\n"), 0644)
+	b := filepath.Join(dir, "sub", "b.go")
+	ioutil.WriteFile(b, []byte("package sub\n\ntype Reader struct{}\n\nfunc (r *Reader) Open() {}\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "testdata", "c.go"), []byte("package c\n\nfunc Open() {}\n"), 0644)
+
+	v := stringToView("package m\n")
+	v.buf.filename = filepath.Join(dir, "new.go")
+	e := newKeyPressEmitter(v)
+	e.emit(":sym ropen", KeyEnter)
+	if msg := string(be.msgLine); msg != "(1 of 1) method Reader.Open" {
+		t.Errorf("wrong message %q", msg)
+	}
+	if v.buf.filename != b || v.cs.line != 4 || v.cs.pos != 17 {
+		t.Errorf("wrong location %v:%v:%v", v.buf.filename, v.cs.line, v.cs.pos)
+	}
+	e.emit(":sym open", KeyEnter)
+	if msg := string(be.msgLine); msg != "(1 of 2) func openFile" {
+		t.Errorf("wrong message %q", msg)
+	}
+	e.emit(":sym zzz", KeyEnter)
+	if msg := string(be.msgLine); msg != "No symbol matching zzz" {
+		t.Errorf("wrong message %q", msg)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFileLines(t *testing.T) {
+	out := "# m\n./a.go:3:10: undefined: x\r\n/abs/b.go:12: no column\n" +
+		"exit status 2\nnot:a location\n"
+	exp := []quickfixEntry{
+		{"/dir/a.go", 3, 10, "undefined: x"},
+		{"/abs/b.go", 12, 0, "no column"},
+	}
+	if entries := parseFileLines("/dir", []byte(out)); !reflect.DeepEqual(entries, exp) {
+		t.Errorf("expected %v, got %v", exp, entries)
+	}
+}
+
+func TestQuickfix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-make")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the files are written without buffers, :cn must open them
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	ioutil.WriteFile(a, []byte("package m\n\nvar x = y\n"), 0644)
+	ioutil.WriteFile(b, []byte("package m\n"), 0644)
+	errors := filepath.Join(dir, "errors")
+	ioutil.WriteFile(errors, []byte(a+":3:9: undefined: y\n"+b+":1:1: other\n"), 0644)
+	defer func(s string) { makeProgram = s }(makeProgram)
+
+	v := stringToView("text\n")
+	e := newKeyPressEmitter(v)
+	checkMsg := func(exp string) {
+		t.Helper()
+		if msg := string(be.msgLine); msg != exp {
+			t.Errorf("expected message %q, got %q", exp, msg)
+		}
+	}
+	e.emit(":makeprg cat", KeyEnter, ":make "+errors, KeyEnter)
+	waitAsync()
+	checkMsg("make: 2 entries")
+	e.emit(":cp", KeyEnter)
+	checkMsg("No previous item")
+	e.emit(":cn", KeyEnter)
+	checkMsg("(1 of 2) undefined: y")
+	if v.buf.filename != a || v.cs.line != 2 || v.cs.pos != 8 {
+		t.Errorf("wrong location %v:%v:%v", v.buf.filename, v.cs.line, v.cs.pos)
+	}
+	e.emit(":cn", KeyEnter)
+	checkMsg("(2 of 2) other")
+	if v.buf.filename != b || be.findBuffer(b) != v.buf {
+		t.Errorf("not in the buffer of %v but %v", b, v.buf.filename)
+	}
+	e.emit(":cn", KeyEnter)
+	checkMsg("No more items")
+	e.emit(":cp", KeyEnter)
+	checkMsg("(1 of 2) undefined: y")
+
+	// the list is shown in its own buffer, where Enter jumps to an entry
+	e.emit(":copen", KeyEnter)
+	if v.buf != r.quickfix.buf || v.cs.line != 0 {
+		t.Fatalf("quickfix list not shown")
+	}
+	if err := equalStrings(viewToString(v), a+":3:9: undefined: y\n"+b+":1:1: other\n"); err != nil {
+		t.Error(err)
+	}
+	e.emit("j", KeyEnter)
+	checkMsg("(2 of 2) other")
+	if v.buf.filename != b {
+		t.Errorf("not in the buffer of %v but %v", b, v.buf.filename)
+	}
+}
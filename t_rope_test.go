@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRopeInsertDelete(t *testing.T) {
+	a := &asserter{}
+	r := newRope(nil)
+	a.assert("new", "len", r.len(), 1)
+	lines := []line{}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, line(strconv.Itoa(i)+"\n"))
+	}
+	r = newRope(lines)
+	a.assert("newRope", "len", r.len(), 5000)
+	a.assert("newRope", "line 4321", string(r.line(4321)), "4321\n")
+	// insert in the middle many times so that leaves get split
+	for i := 0; i < 2000; i++ {
+		r.insert(2500, line("x\n"))
+	}
+	a.assert("insert", "len", r.len(), 7000)
+	a.assert("insert", "line 2499", string(r.line(2499)), "2499\n")
+	a.assert("insert", "line 2500", string(r.line(2500)), "x\n")
+	a.assert("insert", "line 4500", string(r.line(4500)), "2500\n")
+	r.delete(2500, 4500)
+	a.assert("delete", "len", r.len(), 5000)
+	a.assert("delete", "line 2500", string(r.line(2500)), "2500\n")
+	r.insert(r.len(), line("end\n"))
+	a.assert("append", "last line", string(r.line(r.len()-1)), "end\n")
+	r.replace(0, 2, line("a\n"), line("b\n"), line("c\n"))
+	a.assert("replace", "len", r.len(), 5002)
+	a.assert("replace", "line 3", string(r.line(3)), "2\n")
+	r.delete(0, r.len())
+	a.assert("delete all", "len", r.len(), 1)
+	a.assert("delete all", "line 0", string(r.line(0)), "\n")
+	if a.failed {
+		for _, m := range a.errMsgs {
+			t.Error(m)
+		}
+	}
+}
+
+func TestRopeFromBytes(t *testing.T) {
+	a := &asserter{}
+	s := "one\r\ntwo\nthree"
+	r := ropeFromBytes([]byte(s))
+	a.assert("fromBytes", "len", r.len(), 3)
+	a.assert("fromBytes", "text", textToString(r.all()), "one\ntwo\nthree\n")
+	r = ropeFromBytes(nil)
+	a.assert("fromBytes empty", "text", textToString(r.all()), "\n")
+	if a.failed {
+		for _, m := range a.errMsgs {
+			t.Error(m)
+		}
+	}
+}
+
+// largeView returns a view on a buffer with n lines of generated text
+func largeView(n int) *view {
+	b := &strings.Builder{}
+	for i := 0; i < n; i++ {
+		b.WriteString("\tField" + strconv.Itoa(i) + " int32 `protobuf:\"varint\"`\n")
+	}
+	buf := be.newBuffer("")
+	buf.text = ropeFromBytes([]byte(b.String()))
+	buf.mod = normalMode
+	return &view{buf, &mark{n / 2, 0, buf}, 0}
+}
+
+func BenchmarkInsertCharLargeFile(b *testing.B) {
+	v := largeView(200000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.cs.insertChar('x')
+	}
+}
+
+func BenchmarkInsertLineLargeFile(b *testing.B) {
+	v := largeView(200000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.cs.insertNewLineChar()
+	}
+}
+
+func BenchmarkInsertTextLargeFile(b *testing.B) {
+	v := largeView(200000)
+	t := text{line("func a() {\n"), line("\treturn\n"), line("}\n")}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.cs.insertText(t)
+	}
+}
+
+func BenchmarkDeleteLineLargeFile(b *testing.B) {
+	v := largeView(200000 + b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.cs.deleteLine()
+	}
+}
+
+func BenchmarkDeleteRegionLargeFile(b *testing.B) {
+	v := largeView(200000 + 2*b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		region{*v.cs, mark{v.cs.line + 2, 3, v.buf}}.delete()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// failingWriter fails once n bytes were written to w
+type failingWriter struct {
+	w io.Writer
+	n int
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (fw *failingWriter) Write(p []byte) (n int, err error) {
+	if len(p) > fw.n {
+		n, _ = fw.w.Write(p[:fw.n])
+		fw.n = 0
+		return n, errDiskFull
+	}
+	fw.n -= len(p)
+	return fw.w.Write(p)
+}
+
+func TestBufReader(t *testing.T) {
+	src := "añb\n€€€\n\n𝄞x\n"
+	v := stringToView(src)
+	// the runes not fitting in the buffers of the readers are split
+	for _, r := range []io.Reader{
+		&bufReader{buf: v.buf},
+		iotest.OneByteReader(&bufReader{buf: v.buf}),
+		iotest.HalfReader(&bufReader{buf: v.buf}),
+	} {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := equalStrings(string(content), src); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSaveFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(a, []byte("old\n"), 0600)
+	b, err := be.bufferFor(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.removeBuffer(b)
+	v := &view{b, &mark{0, 0, b}, 0}
+	e := newKeyPressEmitter(v)
+	e.emit("ddiline 1", KeyEnter, "line 2", KeyEnter, "line 3", KeyEsc)
+
+	// a failed save leaves the file as it was, without temporary files
+	defer func(fw func(f *os.File) io.Writer) { fileWriter = fw }(fileWriter)
+	fileWriter = func(f *os.File) io.Writer { return &failingWriter{f, 9} }
+	e.emit(":w", KeyEnter)
+	exp := "cannot save " + a + ": line 2: disk full"
+	if msg := string(be.msgLine); msg != exp {
+		t.Errorf("expected message %q, got %q", exp, msg)
+	}
+	if content, _ := ioutil.ReadFile(a); string(content) != "old\n" {
+		t.Errorf("file changed to %q", content)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only a.txt, got %v files", len(files))
+	}
+	if !b.modified {
+		t.Error("buffer not modified after a failed save")
+	}
+
+	// a save keeps the mode of the file, the old content in the backup file
+	fileWriter = func(f *os.File) io.Writer { return f }
+	backupFiles = true
+	defer func() { backupFiles = false }()
+	e.emit(":w", KeyEnter)
+	if msg := string(be.msgLine); msg != "file saved" {
+		t.Errorf("wrong message %q", msg)
+	}
+	if content, _ := ioutil.ReadFile(a); string(content) != "line 1\nline 2\nline 3\n" {
+		t.Errorf("wrong content saved %q", content)
+	}
+	if content, _ := ioutil.ReadFile(a + "~"); string(content) != "old\n" {
+		t.Errorf("wrong backup %q", content)
+	}
+	for _, fp := range []string{a, a + "~"} {
+		if fi, err := os.Stat(fp); err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("%v: wrong mode %v %v", fp, fi.Mode(), err)
+		}
+	}
+	if b.modified {
+		t.Error("buffer modified after a save")
+	}
+}
+
+func TestSaveSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editor-save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target, link := filepath.Join(dir, "target.txt"), filepath.Join(dir, "link.txt")
+	ioutil.WriteFile(target, []byte("a\n"), 0755)
+	if err := os.Symlink("target.txt", link); err != nil {
+		t.Skip("no symbolic links:", err)
+	}
+	b, err := be.bufferFor(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.removeBuffer(b)
+	v := &view{b, &mark{0, 0, b}, 0}
+	newKeyPressEmitter(v).emit("Ab", KeyEsc, ":w", KeyEnter)
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link replaced by a file: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(target); string(content) != "ab\n" {
+		t.Errorf("wrong content saved %q", content)
+	}
+	if fi, err := os.Stat(target); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("wrong mode %v %v", fi.Mode(), err)
+	}
+	// the file saved is not seen as changed on disk
+	if msg := checkFiles(v); strings.Contains(msg, b.name) {
+		t.Errorf("wrong message %q", msg)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -14,10 +15,26 @@ func init() {
 	cmdKeyInsertMode[endOfEmission] = command{allDoneCmd, nil}
 	cmdKeyNormalMode[endOfEmission] = command{allDoneCmd, nil}
 	commandModeKeyTable[endOfEmission] = func() { testChan <- struct{}{} }
+	searchModeKeyTable[endOfEmission] = func() { testChan <- struct{}{} }
 }
 
 func allDoneCmd(ctx *cmdContext) {
-	testChan <- struct{}{}
+	// silent to leave the message of the last command in the message line
+	ctx.silent = true
+	// the test goes on once the command is done, with the updates following
+	// it, like the modified flag of the buffer
+	done := ctx.cmdChans.done
+	ctx.cmdChans.done = make(chan struct{}, 1)
+	go func(c chan struct{}) {
+		done <- <-c
+		testChan <- struct{}{}
+	}(ctx.cmdChans.done)
+}
+
+// waitPosted waits for the commands posted so far by the goroutines working
+// in the background, before the test changes the buffers itself
+func waitPosted() {
+	<-postCmd(func(ctx *cmdContext) { ctx.silent = true })
 }
 
 var (
@@ -41,8 +58,17 @@ func (u *testUI) SplitVertical()         {}
 func (u *testUI) ToPane(dir direction)   {}
 
 func TestMain(m *testing.M) {
+	if os.Getenv(fakeLSPEnv) != "" {
+		// the test binary is run as a language server by TestLSP
+		os.Exit(fakeLSPServer(os.Stdin, os.Stdout))
+	}
 	debug.Println("\nNew test run\n")
 
+	// keep the undo files of the test run away from the user's ones
+	undoDir, _ = ioutil.TempDir("", "editor-undo")
+	// the tests change the buffers outside of the commands, they check the
+	// files with :checktime
+	fileCheckInterval = 0
 	stringToFile(defaultText, testFileName)
 	ui = &testUI{}
 	ui.Init(be.open([]string{testFileName}))
@@ -54,6 +80,7 @@ func TestMain(m *testing.M) {
 		defer cleanup()
 		code = m.Run()
 		os.Remove(testFileName)
+		os.RemoveAll(undoDir)
 		return code
 	}
 	os.Exit(code())
@@ -143,17 +170,13 @@ func stringToLines(s string) []line {
 
 func stringToView(s string) *view {
 	b := be.newBuffer("")
-	b.text = stringToLines(s)
+	b.text = newRope(stringToLines(s))
 	b.mod = normalMode
 	return &view{b, &mark{0, 0, b}, 0}
 }
 
 func viewToString(v *view) string {
-	s := ""
-	for _, line := range v.buf.text {
-		s += string(line)
-	}
-	return s
+	return textToString(v.buf.text.all())
 }
 
 func TestStringToBufferToString(t *testing.T) {
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersistentUndo(t *testing.T) {
+	const fileName = "__undoTestFile__"
+	defer os.Remove(fileName)
+	stringToFile("one two\n", fileName)
+
+	b := be.newBuffer("")
+	if err := be.openFile(b, fileName); err != nil {
+		t.Fatal(err)
+	}
+	v := &view{b, newMark(b), 0}
+	e := newKeyPressEmitter(v)
+	e.emit("dw", "x")
+	if err := b.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the history is back when the file is opened again
+	b2 := be.newBuffer("")
+	if err := be.openFile(b2, fileName); err != nil {
+		t.Fatal(err)
+	}
+	v2 := &view{b2, newMark(b2), 0}
+	e = newKeyPressEmitter(v2)
+	e.emit("u")
+	if err := equalStrings(viewToString(v2), "two\n"); err != nil {
+		t.Fatal(err)
+	}
+	e.emit("u")
+	if err := equalStrings(viewToString(v2), "one two\n"); err != nil {
+		t.Fatal(err)
+	}
+	e.emit(KeyCtrlR)
+	if err := equalStrings(viewToString(v2), "two\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// but not if the file changed
+	stringToFile("three\n", fileName+"2")
+	os.Rename(fileName+"2", fileName)
+	b3 := be.newBuffer("")
+	if err := be.openFile(b3, fileName); err != nil {
+		t.Fatal(err)
+	}
+	if b3.changeList.count != 0 {
+		t.Errorf("undo history loaded for a changed file")
+	}
+}
+
+func TestUndoBudget(t *testing.T) {
+	defer func(n int) { undoBudget = n }(undoBudget)
+	// each x records the line before and after the change
+	undoBudget = 50
+	v := stringToView("abcdefghijkl\n")
+	e := newKeyPressEmitter(v)
+	e.emit("x", "x", "x", "x")
+	cl := v.buf.changeList
+	if cl.size > undoBudget || cl.count != 2 {
+		t.Errorf("undo history over budget: %v bytes in %v changes", cl.size, cl.count)
+	}
+	e.emit("u", "u", "u")
+	if err := equalStrings(viewToString(v), "cdefghijkl\n"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUndoTree(t *testing.T) {
+	v := stringToView("abc\n")
+	e := newKeyPressEmitter(v)
+	check := func(exp string) {
+		t.Helper()
+		if err := equalStrings(viewToString(v), exp); err != nil {
+			t.Error(err)
+		}
+	}
+	// a change after an undo starts a new branch, the old one is kept
+	e.emit("x", "u", "$x")
+	check("ab\n")
+	e.emit("u", KeyCtrlR)
+	check("ab\n")
+	e.emit("g-")
+	check("bc\n")
+	e.emit("g-")
+	check("abc\n")
+	e.emit("g-")
+	check("abc\n")
+	e.emit("2g+")
+	check("ab\n")
+	e.emit(":undolist", KeyEnter)
+	if msg := string(be.msgLine); !strings.HasPrefix(msg, "2 branches: #1 (1 changes") ||
+		!strings.Contains(msg, "*#2 (1 changes") {
+		t.Errorf("wrong branch list: %v", msg)
+	}
+}
+
+func TestUndoTime(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	t0 := time.Now()
+	at := func(d time.Duration) { now = func() time.Time { return t0.Add(d) } }
+	at(0)
+	v := stringToView("abcd\n")
+	e := newKeyPressEmitter(v)
+	check := func(exp string) {
+		t.Helper()
+		if err := equalStrings(viewToString(v), exp); err != nil {
+			t.Error(err)
+		}
+	}
+	at(time.Minute)
+	e.emit("x")
+	at(10 * time.Minute)
+	e.emit("x")
+	at(20 * time.Minute)
+	e.emit("x")
+	check("d\n")
+	e.emit(":earlier 5m", KeyEnter)
+	check("cd\n")
+	e.emit(":earlier 30s", KeyEnter)
+	check("bcd\n")
+	e.emit(":earlier 1h", KeyEnter)
+	check("abcd\n")
+	e.emit(":later 15m", KeyEnter)
+	check("cd\n")
+	e.emit(":later 2", KeyEnter)
+	check("d\n")
+	e.emit(":earlier 2", KeyEnter)
+	check("bcd\n")
+	e.emit(":later 5x", KeyEnter)
+	if msg := string(be.msgLine); msg != "Invalid argument: 5x" {
+		t.Errorf("wrong message: %v", msg)
+	}
+}
+
+func TestPersistentUndoTree(t *testing.T) {
+	const fileName = "__undoTreeTestFile__"
+	defer os.Remove(fileName)
+	stringToFile("abc\n", fileName)
+
+	b := be.newBuffer("")
+	if err := be.openFile(b, fileName); err != nil {
+		t.Fatal(err)
+	}
+	e := newKeyPressEmitter(&view{b, newMark(b), 0})
+	e.emit("x", "u", "$x")
+	if err := b.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	b2 := be.newBuffer("")
+	if err := be.openFile(b2, fileName); err != nil {
+		t.Fatal(err)
+	}
+	v2 := &view{b2, newMark(b2), 0}
+	e = newKeyPressEmitter(v2)
+	e.emit("g-")
+	if err := equalStrings(viewToString(v2), "bc\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndoIndent(t *testing.T) {
+	// the indentation of the lines in insertMode, and backspace over it, are
+	// undone and redone with the text inserted
+	tests := []struct {
+		src, keys, exp string
+		ft             filetype
+	}{
+		{"\tfoo\nbar\n", "A\nx", "\tfoo\n\tx\nbar\n", anyFiletype},
+		{"\t\tfoo\n", "li\n", "\t\n\tfoo\n", anyFiletype},
+		{"func a() {\n\t\ty()\n", "jA\n}", "func a() {\n\t\ty()\n}\n", _go},
+		{"func a() {\n\t\ty()\n\t\t}\n", "jjA)", "func a() {\n\t\ty()\n})\n", _go},
+		{"\t\ty()\n", "A\n}", "\t\ty()\n}\n", _go},
+		// backspace deletes the indentation added or the text before
+		{"\tfoo\nbar\n", "A\n\bx", "\tfoo\nx\nbar\n", anyFiletype},
+		{"\tfoo\nbar\n", "A\n\b\b\bx", "\tfox\nbar\n", anyFiletype},
+	}
+	for _, test := range tests {
+		v := stringToView(test.src)
+		v.buf.filetype = test.ft
+		e := newKeyPressEmitter(v)
+		for _, k := range test.keys {
+			switch k {
+			case '\n':
+				e.emit(KeyEnter)
+			case '\b':
+				e.emit(KeyBackspace)
+			default:
+				e.emit(string(k))
+			}
+		}
+		e.emit(KeyEsc)
+		if err := equalStrings(viewToString(v), test.exp); err != nil {
+			t.Errorf("%q: %v", test.keys, err)
+		}
+		e.emit("u")
+		if err := equalStrings(viewToString(v), test.src); err != nil {
+			t.Errorf("%q, undo: %v", test.keys, err)
+		}
+		e.emit(KeyCtrlR)
+		if err := equalStrings(viewToString(v), test.exp); err != nil {
+			t.Errorf("%q, redo: %v", test.keys, err)
+		}
+	}
+}
@@ -99,16 +99,17 @@ func (p *pane) draw(lineFrom, lineTo, colFrom, colTo int, t *terminal) {
 		p.second.draw(midLine+1, lineTo, colFrom, colTo, t)
 	default:
 		v := p.view
-		text := v.buf.content()
+		text := v.buf.text
 		h := lineTo - lineFrom + 1
 		//w := colTo - colFrom
 		v.fixScroll(h)
 		endline := v.startline + h - 1
-		if endline > len(text)-1 {
-			endline = len(text) - 1
+		if endline > text.len()-1 {
+			endline = text.len() - 1
 		}
 
-		for i, line := range text[v.startline : endline+1] {
+		signs := v.buf.diags.signs()
+		for i, line := range text.lines(v.startline, endline+1) {
 			// draw the (relative) line numbers
 			lineNum := strconv.Itoa(v.relativeLineNumber(v.startline + i))
 			lineNum = lineNumString[:len(lineNumString)-len(lineNum)-1] + lineNum + " "
@@ -116,12 +117,33 @@ func (p *pane) draw(lineFrom, lineTo, colFrom, colTo int, t *terminal) {
 				setCellWithColor(j+colFrom, i+lineFrom, ch, termbox.ColorBlack,
 					termbox.ColorWhite)
 			}
+			// and the sign of the diagnostics in the first column
+			if sev, found := signs[v.startline+i]; found {
+				setCellWithColor(colFrom, i+lineFrom, sev.sign(), severityColors[sev],
+					termbox.ColorWhite)
+			}
 			// viPos tracks the visual position of chars in the line since some chars
 			// might take more than one space on screen
 			viPos := len(lineNumString)
-			for _, ch := range line {
-				setCell(viPos+colFrom, i+lineFrom, ch)
-				viPos += runeWidth(ch)
+			matches := searchMatches(line)
+			for pos, ch := range line {
+				for len(matches) > 0 && matches[0][1] <= pos {
+					matches = matches[1:]
+				}
+				switch {
+				case v.isSelected(v.startline+i, pos):
+					if ch == '\n' {
+						ch = ' '
+					}
+					setCellWithColor(viPos+colFrom, i+lineFrom, ch, defCol|termbox.AttrReverse,
+						defCol)
+				case len(matches) > 0 && matches[0][0] <= pos && ch != '\n':
+					setCellWithColor(viPos+colFrom, i+lineFrom, ch, termbox.ColorBlack,
+						termbox.ColorYellow)
+				default:
+					setCell(viPos+colFrom, i+lineFrom, ch)
+				}
+				viPos += runeWidth(ch, v.buf.tabStop)
 			}
 		}
 		// if we have at least two lines we dispaly the status line
@@ -133,25 +155,37 @@ func (p *pane) draw(lineFrom, lineTo, colFrom, colTo int, t *terminal) {
 
 			//DEBUG code
 			err := fmt.Errorf("len text: %v, cursorLine: %v, len line: "+
-				"%v, cursorPos: %v", len(v.buf.content()), v.cursorLine(),
-				len(v.buf.content()[v.cursorLine()]), v.cursorPos())
-			if (v.cursorLine() < 0 || v.cursorLine() > len(v.buf.content())) ||
-				(v.cursorPos() < 0 || v.cursorPos() > len(v.buf.content()[v.cursorLine()])) {
+				"%v, cursorPos: %v", text.len(), v.cursorLine(),
+				len(text.line(v.cursorLine())), v.cursorPos())
+			if (v.cursorLine() < 0 || v.cursorLine() >= text.len()) ||
+				(v.cursorPos() < 0 || v.cursorPos() > len(text.line(v.cursorLine()))) {
 				fatalError(err)
 				return
 			}
 
-			lineBeforeCs := v.buf.content()[v.cursorLine()][:v.cursorPos()]
-			setCursor(lineVisualWidth(lineBeforeCs)+len(lineNumString)+colFrom,
+			lineBeforeCs := text.line(v.cursorLine())[:v.cursorPos()]
+			setCursor(lineVisualWidth(lineBeforeCs, v.buf.tabStop)+len(lineNumString)+colFrom,
 				v.cursorLine()-v.startline+lineFrom)
 		}
 	}
 }
 
+// severityColors are the colors of the signs of diagnostics
+var severityColors = map[severity]termbox.Attribute{
+	sevError:   termbox.ColorRed,
+	sevWarning: termbox.ColorYellow,
+	sevInfo:    termbox.ColorBlue,
+	sevHint:    termbox.ColorBlue,
+}
+
 func (p *pane) statusLine(line, colFrom, colTo int) {
 	args := p.view.statusLine()
-	s := fmt.Sprintf("Line %v, char %v, raw line %v, total chars %v, total lines %v",
-		p.view.cursorLine()+1, args[0], args[1], args[2], args[3])
+	s := fmt.Sprintf("%v - Line %v, char %v, raw line %v, total chars %v, total lines %v",
+		p.view.title(), p.view.cursorLine()+1, args[0], args[1], args[2], args[3])
+	// the diagnostic under the cursor is more useful
+	if d, found := p.view.buf.diags.at(*p.view.cs); found {
+		s = fmt.Sprintf("%v - Line %v: %v", p.view.title(), p.view.cursorLine()+1, d)
+	}
 	for i, ch := range s {
 		setCellWithColor(i+colFrom, line, ch, termbox.ColorBlack, termbox.ColorWhite)
 	}
@@ -163,7 +197,7 @@ func (p *pane) statusLine(line, colFrom, colTo int) {
 func (t *terminal) drawMessageLine(ln int) {
 	msg := be.MsgLine()
 	if be.CommandMode() == true {
-		setCursor(lineVisualWidth(msg), ln)
+		setCursor(lineVisualWidth(msg, anyFiletype.info().tabStop), ln)
 	}
 	for i, ch := range msg {
 		setCell(i, ln, ch)
@@ -209,8 +243,9 @@ func (t *terminal) PollEvent() UIEvent {
 	}
 }
 
-// runeWidth returns the number of visual spaces the rune takes on screen
-func runeWidth(r rune) int {
+// runeWidth returns the number of visual spaces the rune takes on screen,
+// tabStop for a tab
+func runeWidth(r rune, tabStop int) int {
 	switch r {
 	case '\t':
 		return tabStop
@@ -220,9 +255,9 @@ func runeWidth(r rune) int {
 }
 
 // lineVisualWidth returns the number of visual spaces taken by the line ln
-func lineVisualWidth(ln line) (i int) {
+func lineVisualWidth(ln line, tabStop int) (i int) {
 	for _, r := range ln {
-		i += runeWidth(r)
+		i += runeWidth(r, tabStop)
 	}
 	return i
 }
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// textObjects are the regionFuncs selecting the text object around a mark;
+// unlike motions the regions they return do not start at the mark and
+// their end mark is excluded
+var textObjects = map[string]regionFunc{
+	"iw": innerWord,
+	"aw": aWord,
+	"iW": innerWORD,
+	"aW": aWORD,
+	"is": innerSentence,
+	"as": aSentence,
+	"ip": innerParagraph,
+	"ap": aParagraph,
+}
+
+// linewiseObjects return the first and last line of the text objects made of
+// whole lines, or a last line before the first if there is no object
+var linewiseObjects = map[string]func(m mark) (int, int){
+	"ip": innerParagraphLines,
+	"ap": aParagraphLines,
+}
+
+var quoteChars = []rune{'"', '\'', '`'}
+
+var bracketPairs = [][2]rune{{'(', ')'}, {'[', ']'}, {'{', '}'}, {'<', '>'}}
+
+// we add the quote and bracket objects, then all text objects to regionFuncs
+func init() {
+	for _, q := range quoteChars {
+		q := q
+		textObjects["i"+string(q)] = func(m mark) (region, direction) {
+			return quoteObject(m, q, false), right
+		}
+		textObjects["a"+string(q)] = func(m mark) (region, direction) {
+			return quoteObject(m, q, true), right
+		}
+	}
+	for _, p := range bracketPairs {
+		p := p
+		inner := func(m mark) (region, direction) {
+			return bracketObject(m, p[0], p[1], false), right
+		}
+		around := func(m mark) (region, direction) {
+			return bracketObject(m, p[0], p[1], true), right
+		}
+		for _, ch := range p {
+			textObjects["i"+string(ch)], textObjects["a"+string(ch)] = inner, around
+		}
+	}
+	for k, f := range textObjects {
+		regionFuncs[k] = f
+	}
+}
+
+// objectRegion returns the region of ctx.num text objects ctx.reg starting
+// from the one around the cursor
+func objectRegion(ctx *cmdContext) region {
+	if linewiseObjects[ctx.argString] != nil {
+		return ctx.point.buf.linesRegion(objectLines(ctx))
+	}
+	reg, _ := ctx.reg(*ctx.point)
+	for i := 1; i < ctx.num; i++ {
+		next, _ := ctx.reg(reg.end)
+		if next.start.isBefore(reg.start) {
+			reg.start = next.start
+		}
+		if reg.end.isBefore(next.end) {
+			reg.end = next.end
+		}
+	}
+	return reg
+}
+
+// objectLines returns the first and last line of ctx.num linewise text
+// objects starting from the one around the cursor
+func objectLines(ctx *cmdContext) (int, int) {
+	f := linewiseObjects[ctx.argString]
+	l1, l2 := f(*ctx.point)
+	for i := 1; i < ctx.num && l2 < ctx.point.lastLine(); i++ {
+		_, l2 = f(mark{l2 + 1, 0, ctx.point.buf})
+	}
+	return l1, l2
+}
+
+// regionText returns the text of the region reg of the operator in ctx, as
+// whole lines for linewise text objects
+func regionText(ctx *cmdContext, reg region) (t text, linewise bool) {
+	if linewiseObjects[ctx.argString] == nil {
+		fr, to := orderMarks(reg.start, reg.end)
+		return fr.copy(to), false
+	}
+	l1, l2 := objectLines(ctx)
+	return ctx.point.buf.text.lines(l1, l2+1), true
+}
+
+// charClass returns the class of char c for word objects: 0 for spaces,
+// 1 for word chars and 2 for other chars; if bigWord is true the classes are
+// only spaces and non-spaces
+func charClass(c rune, bigWord bool) int {
+	switch {
+	case unicode.IsSpace(c):
+		return 0
+	case bigWord || isWordChar(c):
+		return 1
+	}
+	return 2
+}
+
+// sameClassSpan returns the start and end (excluded) of the chars around pos
+// in line ln of the same class of the char at pos
+func sameClassSpan(ln line, pos int, bigWord bool) (int, int) {
+	class := charClass(ln[pos], bigWord)
+	start, end := pos, pos+1
+	for start > 0 && charClass(ln[start-1], bigWord) == class {
+		start--
+	}
+	for end < len(ln)-1 && charClass(ln[end], bigWord) == class {
+		end++
+	}
+	return start, end
+}
+
+// wordObject returns the region of the word around m; around includes the
+// white space after the word (or before it if there is none after), or the
+// word after the white space if m is on white space
+func wordObject(m mark, bigWord, around bool) region {
+	ln := m.buf.text.line(m.line)
+	if len(ln) == 1 {
+		return region{m, m}
+	}
+	if m.pos > len(ln)-2 {
+		m.pos = len(ln) - 2
+	}
+	start, end := sameClassSpan(ln, m.pos, bigWord)
+	if around {
+		switch {
+		case charClass(ln[start], bigWord) == 0:
+			if end < len(ln)-1 {
+				_, end = sameClassSpan(ln, end, bigWord)
+			}
+		case end < len(ln)-1 && charClass(ln[end], bigWord) == 0:
+			_, end = sameClassSpan(ln, end, bigWord)
+		case start > 0 && charClass(ln[start-1], bigWord) == 0:
+			start, _ = sameClassSpan(ln, start-1, bigWord)
+		}
+	}
+	return region{mark{m.line, start, m.buf}, mark{m.line, end, m.buf}}
+}
+
+func innerWord(m mark) (region, direction) {
+	return wordObject(m, false, false), right
+}
+
+func aWord(m mark) (region, direction) {
+	return wordObject(m, false, true), right
+}
+
+func innerWORD(m mark) (region, direction) {
+	return wordObject(m, true, false), right
+}
+
+func aWORD(m mark) (region, direction) {
+	return wordObject(m, true, true), right
+}
+
+// quoteObject returns the region of the string quoted with q around m, or
+// of the first one after m in the line. The quotes are included if around is
+// true, together with the white space after the closing quote
+func quoteObject(m mark, q rune, around bool) region {
+	ln := m.buf.text.line(m.line)
+	var quotes []int
+	for i := 0; i < len(ln); i++ {
+		switch ln[i] {
+		case '\\':
+			i++
+		case q:
+			quotes = append(quotes, i)
+		}
+	}
+	for i := 0; i+1 < len(quotes); i += 2 {
+		start, end := quotes[i], quotes[i+1]
+		if end < m.pos {
+			continue
+		}
+		if !around {
+			return region{mark{m.line, start + 1, m.buf}, mark{m.line, end, m.buf}}
+		}
+		end++
+		for end < len(ln)-1 && unicode.IsSpace(ln[end]) {
+			end++
+		}
+		return region{mark{m.line, start, m.buf}, mark{m.line, end, m.buf}}
+	}
+	return region{m, m}
+}
+
+// nextTextChar moves m to the next char in the text, newlines included, and
+// returns false if m is at the end of the text
+func (m *mark) nextTextChar() bool {
+	if m.pos < len(m.buf.text.line(m.line))-1 {
+		m.pos++
+		return true
+	}
+	if m.atLastLine() {
+		return false
+	}
+	m.set(m.line+1, 0)
+	return true
+}
+
+// prevTextChar moves m to the previous char in the text, newlines included, and
+// returns false if m is at the start of the text
+func (m *mark) prevTextChar() bool {
+	if m.pos > 0 {
+		m.pos--
+		return true
+	}
+	if m.atFirstLine() {
+		return false
+	}
+	m.line--
+	m.pos = m.lineEndPos()
+	return true
+}
+
+// bracketObject returns the region enclosed by the innermost pair of brackets
+// open and close around m, brackets included if around is true. An inner
+// region going from the end of a line to a closing bracket preceded only by
+// white space is made of whole lines, so that deleting it leaves the brackets
+// on their lines
+func bracketObject(m mark, open, close rune, around bool) region {
+	start := m
+	switch m.char() {
+	case open:
+	case close:
+		if !start.prevTextChar() ||
+			!findUnmatched(&start, open, close, (*mark).prevTextChar) {
+			return region{m, m}
+		}
+	default:
+		if !findUnmatched(&start, open, close, (*mark).prevTextChar) {
+			return region{m, m}
+		}
+	}
+	end := start
+	if !end.nextTextChar() || !findUnmatched(&end, close, open, (*mark).nextTextChar) {
+		return region{m, m}
+	}
+	return bracketRegion(start, end, around)
+}
+
+// bracketRegion returns the region between the brackets at start and end,
+// brackets included if around is true, made of whole lines like the inner
+// regions of bracketObject
+func bracketRegion(start, end mark, around bool) region {
+	if around {
+		end.pos++
+		return region{start, end}
+	}
+	start.nextTextChar()
+	before := string(end.buf.text.line(end.line)[:end.pos])
+	if start.char() == '\n' && start.line < end.line && strings.TrimSpace(before) == "" {
+		start.set(start.line+1, 0)
+		end.pos = 0
+	}
+	return region{start, end}
+}
+
+// findUnmatched moves m with move until it gets to a char ch not matched by
+// a char other, starting from the char under m, and returns whether it found
+// one
+func findUnmatched(m *mark, ch, other rune, move func(*mark) bool) bool {
+	depth := 0
+	for {
+		switch m.char() {
+		case ch:
+			if depth == 0 {
+				return true
+			}
+			depth--
+		case other:
+			depth++
+		}
+		if !move(m) {
+			return false
+		}
+	}
+}
+
+// isBlankLine returns whether line ln of the text has only white space
+func (b *buffer) isBlankLine(ln int) bool {
+	return strings.TrimSpace(string(b.text.line(ln))) == ""
+}
+
+// paragraphLines returns the first and last line of the paragraph around
+// line ln, that is the lines that are all blank or all not blank like ln
+func (b *buffer) paragraphLines(ln int) (int, int) {
+	blank := b.isBlankLine(ln)
+	l1, l2 := ln, ln
+	for l1 > 0 && b.isBlankLine(l1-1) == blank {
+		l1--
+	}
+	for l2 < b.text.len()-1 && b.isBlankLine(l2+1) == blank {
+		l2++
+	}
+	return l1, l2
+}
+
+// linesRegion returns the region of lines l1 to l2, newlines included;
+// if l2 is the last line the region starts at the newline of the line before
+// l1 instead, so that removing it leaves the text ending with a newline
+func (b *buffer) linesRegion(l1, l2 int) region {
+	if l2 < b.text.len()-1 {
+		return region{mark{l1, 0, b}, mark{l2 + 1, 0, b}}
+	}
+	start, end := mark{l1, 0, b}, mark{l2, 0, b}
+	end.pos = end.lineEndPos()
+	if l1 > 0 {
+		start = mark{l1 - 1, 0, b}
+		start.pos = start.lineEndPos()
+	}
+	return region{start, end}
+}
+
+func innerParagraph(m mark) (region, direction) {
+	return m.buf.linesRegion(innerParagraphLines(m)), right
+}
+
+func aParagraph(m mark) (region, direction) {
+	return m.buf.linesRegion(aParagraphLines(m)), right
+}
+
+func innerParagraphLines(m mark) (int, int) {
+	return m.buf.paragraphLines(m.line)
+}
+
+// aParagraphLines returns the lines of the paragraph around m with the blank
+// lines after it, or before it if there are none after
+func aParagraphLines(m mark) (int, int) {
+	b := m.buf
+	l1, l2 := b.paragraphLines(m.line)
+	switch {
+	case l2 < b.text.len()-1:
+		_, l2 = b.paragraphLines(l2 + 1)
+	case l1 > 0 && !b.isBlankLine(l1):
+		l1, _ = b.paragraphLines(l1 - 1)
+	}
+	return l1, l2
+}
+
+// sentenceEnd returns whether the char at i in chars ends a sentence, that is
+// it is one of .!? followed by closing chars and then white space or the end
+func sentenceEnd(chars []rune, i int) bool {
+	if !strings.ContainsRune(".!?", chars[i]) {
+		return false
+	}
+	for i++; i < len(chars) && strings.ContainsRune(")]\"'", chars[i]); i++ {
+	}
+	return i == len(chars) || unicode.IsSpace(chars[i])
+}
+
+// sentenceObject returns the region of the sentence around m; around
+// includes the white space after the sentence, or before it if there is
+// none after. If m is on the white space between two sentences the region
+// is the white space (followed by the next sentence if around is true)
+func sentenceObject(m mark, around bool) region {
+	b := m.buf
+	if b.isBlankLine(m.line) {
+		reg, _ := innerParagraph(m)
+		return reg
+	}
+	// the chars of the paragraph, without the last newline, and their marks
+	l1, l2 := b.paragraphLines(m.line)
+	var chars []rune
+	var marks []mark
+	cur := 0
+	for ln := l1; ln <= l2; ln++ {
+		line := b.text.line(ln)
+		if ln == l2 {
+			line = line[:len(line)-1]
+		}
+		for pos, ch := range line {
+			if ln == m.line && pos == m.pos {
+				cur = len(chars)
+			}
+			chars = append(chars, ch)
+			marks = append(marks, mark{ln, pos, b})
+		}
+	}
+	end := mark{l2, 0, b}
+	end.pos = end.lineEndPos()
+	marks = append(marks, end)
+	if len(chars) == 0 {
+		return region{m, m}
+	}
+	if cur >= len(chars) {
+		cur = len(chars) - 1
+	}
+	// spans alternate sentences and white space
+	var spans [][2]int
+	for i := 0; i < len(chars); {
+		j := i
+		if unicode.IsSpace(chars[i]) {
+			for j < len(chars) && unicode.IsSpace(chars[j]) {
+				j++
+			}
+		} else {
+			for j < len(chars) && !sentenceEnd(chars, j) {
+				j++
+			}
+			for j < len(chars) && !unicode.IsSpace(chars[j]) {
+				j++
+			}
+		}
+		spans = append(spans, [2]int{i, j})
+		i = j
+	}
+	k := 0
+	for spans[k][1] <= cur {
+		k++
+	}
+	start, stop := spans[k][0], spans[k][1]
+	if around {
+		switch {
+		case k+1 < len(spans):
+			stop = spans[k+1][1]
+		case k > 0 && !unicode.IsSpace(chars[start]):
+			start = spans[k-1][0]
+		}
+	}
+	return region{marks[start], marks[stop]}
+}
+
+func innerSentence(m mark) (region, direction) {
+	return sentenceObject(m, false), right
+}
+
+func aSentence(m mark) (region, direction) {
+	return sentenceObject(m, true), right
+}
@@ -1,98 +1,420 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
 
 const MAX_UNDO = 10000
 
+// undoBudget is the maximum size in bytes of the text kept by a changeList;
+// the oldest changes are dropped to stay within it
+var undoBudget = 4 << 20
+
+// now returns the time recorded with changes, tests replace it
+var now = time.Now
+
+// changeList is the history of the changes to a buffer, for undo / redo. It
+// is a tree: a change made after some undos starts a new branch and the old
+// one is kept
 type changeList struct {
-	ops      []bufferChange
-	current  int  // the position of the next op to undo (0 if none)
-	redoMode bool // if true we are redoing an action, no need to record it
+	root    *undoNode // the text before the oldest change kept
+	current *undoNode // the text of the buffer
+	seq     int       // incremented at each change added
+	count   int       // the number of changes, the nodes but the root
+	size    int       // the size in bytes of the text in the changes
 }
 
-type undoContext struct {
-	text  []line
-	start mark
-	end   mark
+// undoNode is a state of the text in a changeList, reached from its parent
+// with a change
+type undoNode struct {
+	bufferChange
+	parent   *undoNode
+	children []*undoNode // in the order they were added
+	redo     *undoNode   // the child redo moves to, the last one visited
+	seq      int         // the number of the change, in chronological order
+	time     time.Time   // when the change was made
 }
 
-type undoAction int
+// bufferChange is a change to the text of a buffer: the text oldText
+// starting at line and pos was replaced by newText
+type bufferChange struct {
+	line, pos int
+	oldText   text
+	newText   text
+}
 
-const (
-	undoDelete undoAction = iota
-	undoWrite
-	undoReplace
-)
+func (bc bufferChange) size() int {
+	return bc.oldText.size() + bc.newText.size()
+}
 
-type bufferChange struct {
-	redo cmdContext
-	undo undoContext
+// size returns the number of bytes of the text encoded in UTF-8
+func (t text) size() (n int) {
+	for _, ln := range t {
+		for _, ch := range ln {
+			n += utf8.RuneLen(ch)
+		}
+	}
+	return n
+}
+
+// init creates the root of an empty changeList
+func (c *changeList) init() {
+	if c.root == nil {
+		c.root = &undoNode{time: now()}
+		c.current = c.root
+	}
+}
+
+// add records a change made to the current text, as a new branch if changes
+// were undone before it
+func (c *changeList) add(bc bufferChange) {
+	c.init()
+	c.seq++
+	n := &undoNode{bufferChange: bc, parent: c.current, seq: c.seq, time: now()}
+	c.current.children = append(c.current.children, n)
+	c.current.redo = n
+	c.current = n
+	c.count++
+	c.size += bc.size()
+	c.trim()
 }
 
-func (c *changeList) add(redo cmdContext, undo undoContext) {
-	if !c.redoMode {
-		if c.current == MAX_UNDO {
-			c.ops = c.ops[1:]
-			c.current--
+// trim drops the oldest changes to keep the list within MAX_UNDO changes and
+// undoBudget bytes; the current change is always kept. Dropping a change on
+// the path to the current text drops the branches starting before it too
+func (c *changeList) trim() {
+	for c.count > 1 && (c.count > MAX_UNDO || c.size > undoBudget) {
+		oldest := c.root.children[0]
+		for _, n := range c.root.children[1:] {
+			if n.seq < oldest.seq {
+				oldest = n
+			}
+		}
+		if !oldest.isAncestorOf(c.current) {
+			c.dropBranch(oldest)
+			continue
+		}
+		if oldest == c.current {
+			return
 		}
-		c.current++
-		c.ops = append(c.ops[:c.current], bufferChange{newRedoCtx(&redo), undo})
+		for _, n := range c.root.children {
+			if n != oldest {
+				c.dropBranch(n)
+			}
+		}
+		// oldest becomes the root, its change is gone
+		c.count--
+		c.size -= oldest.bufferChange.size()
+		oldest.bufferChange = bufferChange{}
+		oldest.parent = nil
+		c.root = oldest
 	}
 }
 
-func (c *changeList) undo(v *view) string {
-	if c.current == 0 {
+// dropBranch removes from the changeList node n, a child of the root or of
+// another node, and all the changes after it
+func (c *changeList) dropBranch(n *undoNode) {
+	n.walk(func(d *undoNode) {
+		c.count--
+		c.size -= d.bufferChange.size()
+	})
+	p := n.parent
+	ch := p.children
+	for i := range ch {
+		if ch[i] == n {
+			p.children = append(ch[:i:i], ch[i+1:]...)
+			break
+		}
+	}
+	if p.redo == n {
+		p.redo = nil
+		if len(p.children) > 0 {
+			p.redo = p.children[len(p.children)-1]
+		}
+	}
+}
+
+// walk calls f for n and all the nodes under it
+func (n *undoNode) walk(f func(*undoNode)) {
+	f(n)
+	for _, ch := range n.children {
+		ch.walk(f)
+	}
+}
+
+// isAncestorOf returns true if n is m or one of its ancestors
+func (n *undoNode) isAncestorOf(m *undoNode) bool {
+	for ; m != nil; m = m.parent {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// depth returns the number of changes from the root to n
+func (n *undoNode) depth() (d int) {
+	for ; n.parent != nil; n = n.parent {
+		d++
+	}
+	return d
+}
+
+// undo reverts the current change, moving the cursor cs to it
+func (c *changeList) undo(cs *mark) string {
+	c.init()
+	if c.current == c.root {
 		return "No more changes to undo"
 	}
-	ctx := c.ops[c.current].undo
-	// if ctx.end is not set we don't need to delete text
-	if ctx.end.buf != nil {
-		region{ctx.start, ctx.end}.delete()
+	n := c.current
+	c.revert(cs)
+	return fmt.Sprintf("undid change #%v of %v", n.seq, c.seq)
+}
+
+// redo applies again the last change undone, moving the cursor cs to it
+func (c *changeList) redo(cs *mark) string {
+	c.init()
+	if c.current.redo == nil {
+		return "Already at latest change"
 	}
-	if !text(ctx.text).empty() {
-		ctx.start.insertText(ctx.text)
-	} else {
+	c.apply(c.current.redo, cs)
+	return fmt.Sprintf("redid change #%v of %v", c.current.seq, c.seq)
+}
+
+// revert reverts the change of the current node, moving to its parent
+func (c *changeList) revert(cs *mark) {
+	n := c.current
+	start := mark{n.line, n.pos, cs.buf}
+	region{start, start.toEndofText(n.newText)}.remove()
+	if !n.oldText.empty() {
+		start.insertText(n.oldText)
+	} else if !start.atLineStart() {
 		// if we can we move left the cursor to place it before the deleted text
-		if !ctx.start.atLineStart() {
-			ctx.start.moveLeft(1)
+		start.moveLeft(1)
+	}
+	*cs = start
+	c.current = n.parent
+	c.current.redo = n
+}
+
+// apply applies the change of n, a child of the current node, moving to it
+func (c *changeList) apply(n *undoNode, cs *mark) {
+	start := mark{n.line, n.pos, cs.buf}
+	region{start, start.toEndofText(n.oldText)}.remove()
+	start.insertText(n.newText)
+	*cs = start
+	c.current.redo = n
+	c.current = n
+}
+
+// moveTo reverts and applies the changes needed to bring the text to the
+// state of node n
+func (c *changeList) moveTo(n *undoNode, cs *mark) {
+	for !c.current.isAncestorOf(n) {
+		c.revert(cs)
+	}
+	var path []*undoNode
+	for m := n; m != c.current; m = m.parent {
+		path = append(path, m)
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		c.apply(path[i], cs)
+	}
+}
+
+// nodes returns all the nodes of the changeList, in chronological order
+func (c *changeList) nodes() (nodes []*undoNode) {
+	c.init()
+	c.root.walk(func(n *undoNode) { nodes = append(nodes, n) })
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].seq < nodes[j].seq })
+	return nodes
+}
+
+// stepTime moves n changes back (n < 0) or forward in chronological order,
+// whatever the branch
+func (c *changeList) stepTime(n int, cs *mark) string {
+	nodes := c.nodes()
+	i := sort.Search(len(nodes), func(i int) bool { return nodes[i].seq >= c.current.seq })
+	i += n
+	switch {
+	case i < 0 && c.current == c.root:
+		return "Already at oldest change"
+	case i < 0:
+		i = 0
+	case i >= len(nodes) && c.current == nodes[len(nodes)-1]:
+		return "Already at newest change"
+	case i >= len(nodes):
+		i = len(nodes) - 1
+	}
+	c.moveTo(nodes[i], cs)
+	return c.state()
+}
+
+// travelTime moves to the state the text had at time d after (d > 0) or
+// before the time of the current change
+func (c *changeList) travelTime(d time.Duration, cs *mark) string {
+	c.init()
+	t := c.current.time.Add(d)
+	target := c.root
+	for _, n := range c.nodes() {
+		if !n.time.After(t) {
+			target = n
 		}
 	}
+	c.moveTo(target, cs)
+	return c.state()
+}
 
-	*v.cs = ctx.start
-	c.current--
-	return fmt.Sprintf("undid change #%v of %v", c.current+1, len(c.ops)-1)
+// state describes the current node
+func (c *changeList) state() string {
+	if c.current == c.root {
+		return "before the oldest change"
+	}
+	return fmt.Sprintf("at change #%v of %v, %v", c.current.seq, c.seq,
+		c.current.time.Format("15:04:05"))
 }
 
-func (c *changeList) redo(v *view) string {
-	if c.current == len(c.ops)-1 {
-		return "Already at latest change"
+// branches describes the branches of the changeList by their last change
+func (c *changeList) branches() string {
+	var leaves []string
+	for _, n := range c.nodes() {
+		if n == c.root || len(n.children) > 0 {
+			continue
+		}
+		cur := ""
+		if n == c.current {
+			cur = "*"
+		}
+		leaves = append(leaves, fmt.Sprintf("%v#%v (%v changes, %v)", cur, n.seq,
+			n.depth(), n.time.Format("15:04:05")))
+	}
+	if len(leaves) == 0 {
+		return "No changes"
+	}
+	return fmt.Sprintf("%v branches: %v", len(leaves), strings.Join(leaves, ", "))
+}
+
+// addReplace records a change that replaced oldText with newText at start
+func (c *changeList) addReplace(start mark, oldText, newText text) {
+	c.add(bufferChange{start.line, start.pos, copyText(oldText), copyText(newText)})
+}
+
+// recordLinesChange is to be called before changing the lines from l1 to l2
+// included; it returns a function to be called after the change that records
+// it in the changeList. The change can delete lines but must not touch lines
+// outside the range
+func (b *buffer) recordLinesChange(l1, l2 int) func() {
+	oldLen := b.text.len()
+	oldText := b.linesText(l1, l2)
+	return func() {
+		n := l2 - l1 + 1 - (oldLen - b.text.len())
+		start := mark{l1, 0, b}
+		switch {
+		case n > 0:
+			b.changeList.addReplace(start, oldText, b.linesText(l1, l1+n-1))
+		case l1 > b.text.len()-1:
+			// lines deleted at the end of the text, the change starts at the
+			// newline of the line before
+			start = mark{l1 - 1, 0, b}
+			start.pos = start.lineEndPos()
+			b.changeList.addReplace(start, append(text{line{'\n'}}, oldText...), nil)
+		default:
+			last := len(oldText) - 1
+			oldText[last] = append(append(line{}, oldText[last]...), '\n')
+			b.changeList.addReplace(start, oldText, nil)
+		}
+	}
+}
+
+// groupChanges turns the changes made to b since its changeList was at node
+// n into a single change, to be undone at once; the cursor cs stays where it
+// is
+func (b *buffer) groupChanges(n *undoNode, cs *mark) {
+	c := &b.changeList
+	if n == nil || n == c.current || !n.isAncestorOf(c.current) ||
+		n != c.root && !c.root.isAncestorOf(n) {
+		return
 	}
-	c.redoMode = true
-	c.current++
-	ctx := c.ops[c.current].redo
-	p := *ctx.point
-	ctx.point = &p
-	pushCmd(&ctx)
-	*v.cs = *ctx.point
-	c.redoMode = false
-	return fmt.Sprintf("redid change #%v of %v", c.current, len(c.ops)-1)
+	lines, pos := copyText(b.text.all()), *cs
+	c.moveTo(n, cs)
+	c.dropBranch(n.redo)
+	b.replaceText(lines, cs)
+	*cs = pos
+	cs.fixLineAndPos()
 }
 
 func undo(ctx *cmdContext) {
 	for i := 0; i < ctx.num; i++ {
-		ctx.msg = ctx.point.buf.changeList.undo(ctx.view)
+		ctx.msg = ctx.point.buf.changeList.undo(ctx.point)
 	}
 }
 
 func redo(ctx *cmdContext) {
 	for i := 0; i < ctx.num; i++ {
-		ctx.msg = ctx.point.buf.changeList.redo(ctx.view)
+		ctx.msg = ctx.point.buf.changeList.redo(ctx.point)
+	}
+}
+
+// undoTime moves n changes back in time, or forward for g+
+func undoTime(ctx *cmdContext) {
+	n := -ctx.num
+	if ctx.cmdString == "g+" {
+		n = ctx.num
+	}
+	ctx.msg = ctx.point.buf.changeList.stepTime(n, ctx.point)
+}
+
+// earlier moves back in time by a number of changes or a duration like 30s,
+// 5m, 2h or 1d
+func earlier(v *view, args []string) (msg string) {
+	return travel(v, args, -1)
+}
+
+// later moves forward in time as earlier moves back
+func later(v *view, args []string) (msg string) {
+	return travel(v, args, 1)
+}
+
+func travel(v *view, args []string, dir int) string {
+	arg := "1"
+	if len(args) > 1 {
+		return "Usage: earlier|later [count|{n}s|{n}m|{n}h|{n}d]"
+	} else if len(args) == 1 {
+		arg = args[0]
+	}
+	steps, d, err := parseUndoTime(arg)
+	if err != nil {
+		return err.Error()
+	}
+	cl := &v.buf.changeList
+	if steps != 0 {
+		return cl.stepTime(dir*steps, v.cs)
+	}
+	return cl.travelTime(time.Duration(dir)*d, v.cs)
+}
+
+// parseUndoTime parses the argument of :earlier and :later, a count of
+// changes or a duration
+func parseUndoTime(arg string) (steps int, d time.Duration, err error) {
+	if n, err := strconv.Atoi(arg); err == nil && n > 0 {
+		return n, 0, nil
+	}
+	n, unit := leadingNumber(arg)
+	units := map[string]time.Duration{"s": time.Second, "m": time.Minute,
+		"h": time.Hour, "d": 24 * time.Hour}
+	if u, found := units[unit]; found && n > 0 && unit != arg {
+		return 0, time.Duration(n) * u, nil
 	}
+	return 0, 0, errors.New("Invalid argument: " + arg)
 }
 
-func newRedoCtx(ctx *cmdContext) cmdContext {
-	p := *ctx.point
-	ctx.point = &p
-	ctx.silent = true
-	return *ctx
+// undoList reports the branches of the undo tree
+func undoList(v *view, args []string) (msg string) {
+	return v.buf.changeList.branches()
 }
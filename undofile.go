@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// undoDir is the directory where the undo history of files is saved
+var undoDir = defaultUndoDir()
+
+func defaultUndoDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "editor", "undo")
+}
+
+// undoFile is the undo history of a file saved on disk; it is valid only for
+// the content of the file with hash Hash
+type undoFile struct {
+	Path     string
+	Hash     []byte
+	RootSeq  int // the seq of the root of the changeList
+	RootTime time.Time
+	Current  int // the seq of the current node
+	Changes  []savedChange
+}
+
+// savedChange is a node of a changeList as saved in an undoFile, after its
+// parent
+type savedChange struct {
+	Seq, Parent      int
+	Redo             bool // if true redo moves to this node from its parent
+	Time             time.Time
+	Line, Pos        int
+	OldText, NewText []string
+}
+
+// undoFilePath returns the path of the undo file for the file at path fp
+func undoFilePath(fp string) string {
+	h := sha256.Sum256([]byte(fp))
+	return filepath.Join(undoDir, hex.EncodeToString(h[:16]))
+}
+
+// contentHash returns the hash identifying the content of a file
+func contentHash(content []byte) []byte {
+	h := sha256.Sum256(content)
+	return h[:]
+}
+
+// saveUndo saves the changeList of buffer b in its undo file, for the file
+// content with hash hash
+func (b *buffer) saveUndo(hash []byte) error {
+	cl := &b.changeList
+	nodes := cl.nodes()
+	uf := undoFile{Path: b.filename, Hash: hash, RootSeq: cl.root.seq,
+		RootTime: cl.root.time, Current: cl.current.seq}
+	for _, n := range nodes[1:] {
+		uf.Changes = append(uf.Changes, savedChange{n.seq, n.parent.seq,
+			n.parent.redo == n, n.time, n.line, n.pos,
+			textToStrings(n.oldText), textToStrings(n.newText)})
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(uf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(undoDir, 0700); err != nil {
+		return err
+	}
+	// write and rename, so that a failed write does not corrupt the old file
+	fp := undoFilePath(b.filename)
+	tmp := fp + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp)
+}
+
+// loadUndo loads the changeList of buffer b from its undo file if the file
+// was saved for the content with hash hash; it returns whether it did
+func (b *buffer) loadUndo(hash []byte) bool {
+	f, err := os.Open(undoFilePath(b.filename))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var uf undoFile
+	if err := gob.NewDecoder(f).Decode(&uf); err != nil {
+		debug.Printf("undo file of %v: %v", b.filename, err)
+		return false
+	}
+	if uf.Path != b.filename || !bytes.Equal(uf.Hash, hash) {
+		return false
+	}
+	root := &undoNode{seq: uf.RootSeq, time: uf.RootTime}
+	cl := changeList{root: root, seq: uf.RootSeq}
+	nodes := map[int]*undoNode{root.seq: root}
+	for _, sc := range uf.Changes {
+		parent, found := nodes[sc.Parent]
+		if !found || nodes[sc.Seq] != nil {
+			debug.Printf("undo file of %v: invalid change %v", b.filename, sc.Seq)
+			return false
+		}
+		n := &undoNode{bufferChange{sc.Line, sc.Pos, stringsToText(sc.OldText),
+			stringsToText(sc.NewText)}, parent, nil, nil, sc.Seq, sc.Time}
+		parent.children = append(parent.children, n)
+		if sc.Redo {
+			parent.redo = n
+		}
+		nodes[n.seq] = n
+		cl.count++
+		cl.size += n.bufferChange.size()
+		if n.seq > cl.seq {
+			cl.seq = n.seq
+		}
+	}
+	if cl.current = nodes[uf.Current]; cl.current == nil {
+		return false
+	}
+	cl.trim()
+	b.changeList = cl
+	return true
+}
+
+func textToStrings(t text) []string {
+	s := make([]string, len(t))
+	for i, ln := range t {
+		s[i] = string(ln)
+	}
+	return s
+}
+
+func stringsToText(s []string) text {
+	t := make(text, len(s))
+	for i, ln := range s {
+		t[i] = line(ln)
+	}
+	return t
+}
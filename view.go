@@ -12,6 +12,18 @@ func copyView(v *view) *view {
 	return &view{v.buf, &mark{v.cs.line, v.cs.pos, v.cs.buf}, v.startline}
 }
 
+// moveTo moves the cursor of view v to m, showing the buffer of m; the
+// buffer shown before keeps its cursor and becomes the alternate buffer
+func (v *view) moveTo(m mark) {
+	if m.buf != v.buf {
+		v.buf.savedCursor = *v.cs
+		be.alternate = v.buf
+		v.buf = m.buf
+		v.startline = 0
+	}
+	*v.cs = m
+}
+
 const cursorLinesToMargin = 5
 
 // cursorline returns the line number of the buffer cursor
@@ -24,10 +36,19 @@ func (v *view) cursorPos() int {
 	return v.cs.pos
 }
 
+// title returns the name of the buffer of v, followed by [+] if it is
+// modified
+func (v *view) title() string {
+	if v.buf.modified {
+		return v.buf.name + " [+]"
+	}
+	return v.buf.name
+}
+
 // statusLine returns the buffer statusline
 func (v *view) statusLine() []interface{} {
 	cs := v.cs
-	return []interface{}{cs.pos + 1, fmt.Sprintf("%q", v.buf.text[cs.line]),
+	return []interface{}{cs.pos + 1, fmt.Sprintf("%q", v.buf.text.line(cs.line)),
 		cs.lastCharPos() + 1, cs.lastLine() + 1}
 }
 
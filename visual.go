@@ -0,0 +1,319 @@
+package main
+
+// visualKind is the kind of selection made in visualMode
+type visualKind int
+
+const (
+	charwise visualKind = iota
+	linewise
+	blockwise
+)
+
+var visualMsgs = map[visualKind]string{
+	charwise:  "-- VISUAL --",
+	linewise:  "-- VISUAL LINE --",
+	blockwise: "-- VISUAL BLOCK --",
+}
+
+// visualSelection is the selection of a buffer in visualMode, which goes from
+// the anchor to the cursor
+type visualSelection struct {
+	kind   visualKind
+	anchor mark
+}
+
+var cmdKeyVisualMode = map[Key]command{
+	KeyEsc:   command{exitVisualMode, nil},
+	KeyCtrlC: command{exitVisualMode, nil},
+	KeyCtrlV: command{enterVisualBlockMode, nil},
+	KeyCtrlS: command{saveToFile, nil},
+}
+
+var cmdStringVisualMode = map[string]command{
+	"v": command{enterVisualMode, nil},
+	"V": command{enterVisualLineMode, nil},
+	"o": command{swapVisualAnchor, nil},
+	"h": command{moveCursorLeft, nil},
+	"j": command{moveCursorDown, nil},
+	"k": command{moveCursorUp, nil},
+	"l": command{moveCursorRight, nil},
+	"d": command{visualDelete, nil},
+	"x": command{visualDelete, nil},
+	"y": command{visualYank, nil},
+	"c": command{visualChange, nil},
+	"s": command{visualChange, nil},
+	">": command{visualShiftRight, nil},
+	"<": command{visualShiftLeft, nil},
+	"=": command{visualIndent, nil},
+	"/": command{searchForward, nil},
+	"?": command{searchBackward, nil},
+	":": command{visualCommandMode, nil},
+}
+
+// all motions extend the selection
+func init() {
+	for k := range motions {
+		cmdStringVisualMode[k] = command{moveCursorTo, nil}
+	}
+}
+
+func enterVisualMode(ctx *cmdContext) {
+	toggleVisualMode(ctx, charwise)
+}
+
+func enterVisualLineMode(ctx *cmdContext) {
+	toggleVisualMode(ctx, linewise)
+}
+
+func enterVisualBlockMode(ctx *cmdContext) {
+	toggleVisualMode(ctx, blockwise)
+}
+
+// toggleVisualMode enters visualMode with a selection of kind k anchored at
+// the cursor; if already in visualMode it changes the selection kind, or exits
+// visualMode if the kind is the same
+func toggleVisualMode(ctx *cmdContext, k visualKind) {
+	b := ctx.point.buf
+	if b.mod == visualMode {
+		if b.visual.kind == k {
+			exitVisualMode(ctx)
+			return
+		}
+		b.visual.kind = k
+		ctx.msg = visualMsgs[k]
+		return
+	}
+	defer ctx.point.setMode(visualMode)(ctx.point)
+	b.visual = visualSelection{k, *ctx.point}
+	ctx.msg = visualMsgs[k]
+}
+
+func exitVisualMode(ctx *cmdContext) {
+	if ctx.point.buf.mod != visualMode {
+		return
+	}
+	defer ctx.point.setMode(normalMode)(ctx.point)
+	b := ctx.point.buf
+	fr, to := orderMarks(b.visual.anchor, *ctx.point)
+	b.setMark('<', fr)
+	b.setMark('>', to)
+	ctx.point.fixPos()
+	ctx.msg = "Normal mode"
+}
+
+// visualCommandMode enters commandMode with the range of the selected lines
+func visualCommandMode(ctx *cmdContext) {
+	exitVisualMode(ctx)
+	enterCommandMode(ctx)
+	ctx.msg += "'<,'>"
+}
+
+// swapVisualAnchor moves the cursor to the other end of the selection
+func swapVisualAnchor(ctx *cmdContext) {
+	b := ctx.point.buf
+	*ctx.point, b.visual.anchor = b.visual.anchor, *ctx.point
+}
+
+// selectedLines returns the first and last line of the selection made with
+// cursor cs
+func (b *buffer) selectedLines(cs mark) (int, int) {
+	fr, to := orderMarks(b.visual.anchor, cs)
+	return fr.line, to.line
+}
+
+// selectionRegions returns the regions selected by the buffer selection with
+// cursor cs; region ends are excluded. A charwise selection is one region, a
+// linewise one is one region of whole lines, newlines included, and a block
+// selection is one region per line
+func (b *buffer) selectionRegions(cs mark) []region {
+	fr, to := orderMarks(b.visual.anchor, cs)
+	switch b.visual.kind {
+	case linewise:
+		end := mark{to.line, to.lineEndPos() + 1, b}
+		return []region{region{mark{fr.line, 0, b}, end}}
+	case blockwise:
+		c1, c2 := b.visual.anchor.pos, cs.pos
+		if c1 > c2 {
+			c1, c2 = c2, c1
+		}
+		var regs []region
+		for ln := fr.line; ln <= to.line; ln++ {
+			start, end := mark{ln, c1, b}, mark{ln, c2 + 1, b}
+			if max := start.lineEndPos(); end.pos > max {
+				end.pos = max
+				if start.pos > max {
+					start.pos = max
+				}
+			}
+			regs = append(regs, region{start, end})
+		}
+		return regs
+	default:
+		if to.atLineEnd() && !to.atLastLine() {
+			to.set(to.line+1, 0)
+		} else if !to.atLineEnd() {
+			to.pos++
+		}
+		return []region{region{fr, to}}
+	}
+}
+
+// isSelected returns whether the char at line ln and position pos is part of
+// the view's selection
+func (v *view) isSelected(ln, pos int) bool {
+	b := v.buf
+	if b.mod != visualMode {
+		return false
+	}
+	fr, to := b.selectedLines(*v.cs)
+	if ln < fr || ln > to {
+		return false
+	}
+	for _, r := range b.selectionRegions(*v.cs) {
+		if r.start.line > ln || r.end.line < ln {
+			continue
+		}
+		if (r.start.line < ln || r.start.pos <= pos) &&
+			(r.end.line > ln || r.end.pos > pos) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedText returns the text of the selection, with block selections
+// returned as one line of text per selected line
+func (b *buffer) selectedText(cs mark) text {
+	regs := b.selectionRegions(cs)
+	if b.visual.kind != blockwise {
+		return regs[0].start.copy(regs[0].end)
+	}
+	var t text
+	for _, r := range regs {
+		t = append(t, append(append(line{}, r.start.copy(r.end)[0]...), '\n'))
+	}
+	return t
+}
+
+// linesText returns a copy of the lines from l1 to l2 included, without
+// the final newline char so that it can be used with region.replace
+func (b *buffer) linesText(l1, l2 int) text {
+	t := text(b.text.lines(l1, l2+1))
+	last := t[len(t)-1]
+	t[len(t)-1] = last[:len(last)-1]
+	return t
+}
+
+func visualDelete(ctx *cmdContext) {
+	b := ctx.point.buf
+	fr, to := b.selectedLines(*ctx.point)
+	switch b.visual.kind {
+	case linewise:
+		*ctx.point = mark{fr, 0, b}
+		ctx.num = to - fr + 1
+		ctx.cmd = deleteLine
+		exitVisualMode(ctx)
+		deleteLine(ctx)
+	case blockwise:
+		oldText := b.linesText(fr, to)
+		regs := b.selectionRegions(*ctx.point)
+		r.texts.delete(ctx.register, b.selectedText(*ctx.point), false)
+		for _, r := range regs {
+			r.remove()
+		}
+		b.changeList.addReplace(mark{fr, 0, b}, oldText, b.linesText(fr, to))
+		*ctx.point = regs[0].start
+		exitVisualMode(ctx)
+	default:
+		reg := b.selectionRegions(*ctx.point)[0]
+		deleted := reg.start.copy(reg.end)
+		r.texts.delete(ctx.register, deleted, false)
+		b.changeList.addReplace(reg.start, deleted, nil)
+		*ctx.point = reg.remove()
+		exitVisualMode(ctx)
+	}
+}
+
+func visualYank(ctx *cmdContext) {
+	b := ctx.point.buf
+	r.texts.yank(ctx.register, b.selectedText(*ctx.point), b.visual.kind == linewise)
+	*ctx.point = b.selectionRegions(*ctx.point)[0].start
+	exitVisualMode(ctx)
+}
+
+// visualChange deletes the selection and enters insertMode; linewise
+// selections leave an empty line, indented like the first line, to insert
+// text in
+func visualChange(ctx *cmdContext) {
+	b := ctx.point.buf
+	switch b.visual.kind {
+	case linewise:
+		fr, to := b.selectedLines(*ctx.point)
+		exitVisualMode(ctx)
+		changeLines(ctx, fr, to)
+	case blockwise:
+		visualDelete(ctx)
+		insertAtCs(ctx)
+	default:
+		reg := b.selectionRegions(*ctx.point)[0]
+		r.texts.delete(ctx.register, reg.start.copy(reg.end), false)
+		exitVisualMode(ctx)
+		changeRegion(ctx, reg)
+	}
+}
+
+func visualShiftRight(ctx *cmdContext) {
+	shiftSelection(ctx, true)
+}
+
+func visualShiftLeft(ctx *cmdContext) {
+	shiftSelection(ctx, false)
+}
+
+// visualIndent re-indents the selected lines
+func visualIndent(ctx *cmdContext) {
+	b := ctx.point.buf
+	fr, to := b.selectedLines(*ctx.point)
+	b.indentLines(fr, to)
+	*ctx.point = mark{fr, 0, b}
+	_, ctx.point.pos = lineIndent(b, fr)
+	exitVisualMode(ctx)
+}
+
+// shiftSelection indents (or outdents) ctx.num times the selected lines
+func shiftSelection(ctx *cmdContext, right bool) {
+	b := ctx.point.buf
+	fr, to := b.selectedLines(*ctx.point)
+	oldText := b.linesText(fr, to)
+	for ln := fr; ln <= to; ln++ {
+		for i := 0; i < ctx.num; i++ {
+			b.shiftLine(ln, right)
+		}
+	}
+	b.changeList.addReplace(mark{fr, 0, b}, oldText, b.linesText(fr, to))
+	*ctx.point = mark{fr, 0, b}
+	_, ctx.point.pos = lineIndent(b, fr)
+	exitVisualMode(ctx)
+}
+
+// shiftLine adds one level of indentation to line ln, or removes one if right
+// is false; empty lines are left alone
+func (b *buffer) shiftLine(ln int, right bool) {
+	l := b.text.line(ln)
+	if len(l) == 1 {
+		return
+	}
+	if right {
+		b.text.setLine(ln, append(b.tab(), l...))
+		return
+	}
+	// remove a tab or up to tabStop spaces
+	n := 0
+	for n < len(l) && n < b.tabStop && l[n] == ' ' {
+		n++
+	}
+	if n == 0 && l[0] == '\t' {
+		n = 1
+	}
+	b.text.setLine(ln, append(line{}, l[n:]...))
+}